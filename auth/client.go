@@ -1,25 +1,57 @@
 package auth
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/csenet/instanton-exporter/breaker"
 	"github.com/csenet/instanton-exporter/models"
 )
 
+// tokenRefreshSkew is how long before expiry the access token is refreshed,
+// so that requests in flight never carry a token that lapses mid-call.
+const tokenRefreshSkew = 60 * time.Second
+
+// Client obtains and caches access tokens for the Instant On API.
+//
+// GetToken, GetTokenContext and InvalidateToken are safe for concurrent use.
+// The individual login steps (FetchSettings, GetTemporaryAccessToken,
+// GetAuthorizationCode, GetAccessToken, RefreshAccessToken and their Context
+// variants) are not; GetToken drives them from a single in-flight
+// acquisition.
 type Client struct {
 	httpClient    *http.Client
 	settings      *models.Settings
 	username      string
 	password      string
-	token         *models.AuthToken
 	sessionToken  string
 	pkceChallenge *PKCEChallenge
+	store         TokenStore
+	storeLoaded   bool
+	mfaCode       MFACodeFunc
+	breaker       *breaker.Breaker
+	baseCtx       context.Context
+
+	// mu guards token, expiresAt and inflight
+	mu        sync.Mutex
+	token     *models.AuthToken
+	expiresAt time.Time
+	inflight  *tokenCall
+}
+
+// tokenCall is a token acquisition shared by every caller that finds the
+// cached token unusable while it is running.
+type tokenCall struct {
+	done  chan struct{}
+	token string
+	err   error
 }
 
 func NewClient(username, password string) *Client {
@@ -29,11 +61,53 @@ func NewClient(username, password string) *Client {
 		},
 		username: username,
 		password: password,
+		baseCtx:  context.Background(),
 	}
 }
 
+// SetBaseContext sets the context shared token acquisitions run under, so
+// cancelling it aborts in-flight SSO calls. It must be called before
+// GetToken.
+func (c *Client) SetBaseContext(ctx context.Context) {
+	c.baseCtx = ctx
+}
+
+// SetTimeout sets the HTTP timeout for requests to the SSO.
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// SetTokenStore makes the client persist its tokens to store and restore
+// them from it before the first login. It must be called before GetToken.
+func (c *Client) SetTokenStore(store TokenStore) {
+	c.store = store
+}
+
+// SetCircuitBreaker makes the client stop contacting the SSO for a while
+// after token acquisitions keep failing, so a wrong password or an SSO
+// outage does not end in an account lockout. It must be called before
+// GetToken.
+func (c *Client) SetCircuitBreaker(b *breaker.Breaker) {
+	c.breaker = b
+}
+
+// SetMFACodeFunc sets how second-factor codes are obtained when the account
+// has two-factor authentication enabled.
+func (c *Client) SetMFACodeFunc(fn MFACodeFunc) {
+	c.mfaCode = fn
+}
+
 func (c *Client) FetchSettings() error {
-	resp, err := c.httpClient.Get("https://portal.arubainstanton.com/settings.json")
+	return c.FetchSettingsContext(context.Background())
+}
+
+func (c *Client) FetchSettingsContext(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", "https://portal.arubainstanton.com/settings.json", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create settings request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to fetch settings: %w", err)
 	}
@@ -55,8 +129,12 @@ func (c *Client) FetchSettings() error {
 }
 
 func (c *Client) GetTemporaryAccessToken() error {
+	return c.GetTemporaryAccessTokenContext(context.Background())
+}
+
+func (c *Client) GetTemporaryAccessTokenContext(ctx context.Context) error {
 	if c.settings == nil {
-		if err := c.FetchSettings(); err != nil {
+		if err := c.FetchSettingsContext(ctx); err != nil {
 			return err
 		}
 	}
@@ -69,7 +147,7 @@ func (c *Client) GetTemporaryAccessToken() error {
 	mfaURL := "https://sso.arubainstanton.com/aio/api/v1/mfa/validate/full"
 
 
-	req, err := http.NewRequest("POST", mfaURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", mfaURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return fmt.Errorf("failed to create MFA request: %w", err)
 	}
@@ -87,6 +165,11 @@ func (c *Client) GetTemporaryAccessToken() error {
 		return fmt.Errorf("failed to read MFA response: %w", err)
 	}
 
+	// Accounts with two-factor authentication get a challenge instead of a token
+	var challenge models.MFAResponse
+	if err := json.Unmarshal(body, &challenge); err == nil && challenge.RequiresMFA {
+		return c.completeMFA(ctx, &challenge)
+	}
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("MFA validation failed: status %d, body: %s", resp.StatusCode, string(body))
@@ -111,9 +194,71 @@ func (c *Client) GetTemporaryAccessToken() error {
 	return nil
 }
 
+// completeMFA answers the challenge of an account with two-factor
+// authentication. The challenge fields and the code validation request are
+// modelled after the password validation and have not been confirmed against
+// the SSO.
+func (c *Client) completeMFA(ctx context.Context, challenge *models.MFAResponse) error {
+	if c.mfaCode == nil {
+		return ErrMFARequired
+	}
+
+	code, err := c.mfaCode()
+	if err != nil {
+		return fmt.Errorf("failed to get MFA code: %w", err)
+	}
+
+	data := url.Values{}
+	data.Set("session_id", challenge.SessionID)
+	data.Set("token", challenge.Token)
+	data.Set("code", code)
+
+	mfaURL := "https://sso.arubainstanton.com/aio/api/v1/mfa/validate/code"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", mfaURL, strings.NewReader(data.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create MFA code request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send MFA code request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read MFA code response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("MFA code validation failed: status %d, body: %s", resp.StatusCode, string(body))
+	}
+
+	var mfaResp models.AuthToken
+	if err := json.Unmarshal(body, &mfaResp); err != nil {
+		return fmt.Errorf("failed to decode MFA code response: %w", err)
+	}
+
+	if !mfaResp.Success || mfaResp.AccessToken == "" {
+		return fmt.Errorf("MFA code was not accepted")
+	}
+
+	c.sessionToken = mfaResp.AccessToken
+	fmt.Printf("[INFO] Session token obtained after MFA (expires in %d seconds)\n", mfaResp.ExpiresIn)
+
+	return nil
+}
+
 func (c *Client) GetAuthorizationCode() (string, error) {
+	return c.GetAuthorizationCodeContext(context.Background())
+}
+
+func (c *Client) GetAuthorizationCodeContext(ctx context.Context) (string, error) {
 	if c.sessionToken == "" {
-		if err := c.GetTemporaryAccessToken(); err != nil {
+		if err := c.GetTemporaryAccessTokenContext(ctx); err != nil {
 			return "", err
 		}
 	}
@@ -140,12 +285,19 @@ func (c *Client) GetAuthorizationCode() (string, error) {
 
 	// Make GET request with redirect disabled to capture the authorization code
 	client := &http.Client{
+		Transport: c.httpClient.Transport,
+		Timeout:   c.httpClient.Timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
 		},
 	}
 
-	resp, err := client.Get(authURL)
+	req, err := http.NewRequestWithContext(ctx, "GET", authURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create authorization request: %w", err)
+	}
+
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to get authorization code: %w", err)
 	}
@@ -175,14 +327,18 @@ func (c *Client) GetAuthorizationCode() (string, error) {
 }
 
 func (c *Client) GetAccessToken() error {
+	return c.GetAccessTokenContext(context.Background())
+}
+
+func (c *Client) GetAccessTokenContext(ctx context.Context) error {
 	if c.settings == nil {
-		if err := c.FetchSettings(); err != nil {
+		if err := c.FetchSettingsContext(ctx); err != nil {
 			return err
 		}
 	}
 
 	// Get authorization code
-	code, err := c.GetAuthorizationCode()
+	code, err := c.GetAuthorizationCodeContext(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to get authorization code: %w", err)
 	}
@@ -195,48 +351,305 @@ func (c *Client) GetAccessToken() error {
 	data.Set("code", code)
 	data.Set("code_verifier", c.pkceChallenge.Verifier)
 
-	tokenURL := fmt.Sprintf("%s%s", c.settings.SSOBaseURL, c.settings.SSOEndpointTokens)
+	tokenResp, err := c.requestToken(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	c.setToken(tokenResp)
+	fmt.Printf("[INFO] Access token obtained (expires in %d seconds)\n", tokenResp.ExpiresIn)
+
+	return nil
+}
+
+func (c *Client) RefreshAccessToken() error {
+	return c.RefreshAccessTokenContext(context.Background())
+}
+
+func (c *Client) RefreshAccessTokenContext(ctx context.Context) error {
+	refreshToken := c.refreshToken()
+	if refreshToken == "" {
+		return fmt.Errorf("no refresh token available")
+	}
+
+	if c.settings == nil {
+		if err := c.FetchSettingsContext(ctx); err != nil {
+			return err
+		}
+	}
+
+	data := url.Values{}
+	data.Set("grant_type", "refresh_token")
+	data.Set("client_id", c.settings.SSOClientIDAuthZ)
+	data.Set("refresh_token", refreshToken)
+
+	tokenResp, err := c.requestToken(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	// The SSO does not always rotate the refresh token; keep the old one if none was returned
+	if tokenResp.RefreshToken == "" {
+		tokenResp.RefreshToken = refreshToken
+	}
 
+	c.setToken(tokenResp)
+	fmt.Printf("[INFO] Access token refreshed (expires in %d seconds)\n", tokenResp.ExpiresIn)
+
+	return nil
+}
+
+func (c *Client) requestToken(ctx context.Context, data url.Values) (*models.AuthToken, error) {
+	tokenURL := fmt.Sprintf("%s%s", c.settings.SSOBaseURL, c.settings.SSOEndpointTokens)
 
-	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		return fmt.Errorf("failed to create token request: %w", err)
+		return nil, fmt.Errorf("failed to create token request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to send token request: %w", err)
+		return nil, fmt.Errorf("failed to send token request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read token response: %w", err)
+		return nil, fmt.Errorf("failed to read token response: %w", err)
 	}
 
-
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("token exchange failed: status %d, body: %s", resp.StatusCode, string(body))
+		return nil, fmt.Errorf("token request failed: status %d, body: %s", resp.StatusCode, string(body))
 	}
 
 	var tokenResp models.AuthToken
 	if err := json.Unmarshal(body, &tokenResp); err != nil {
-		return fmt.Errorf("failed to decode token response: %w", err)
+		return nil, fmt.Errorf("failed to decode token response: %w", err)
 	}
 
-	c.token = &tokenResp
-	fmt.Printf("[INFO] Access token obtained (expires in %d seconds)\n", tokenResp.ExpiresIn)
+	if tokenResp.AccessToken == "" {
+		return nil, fmt.Errorf("no access token received from token endpoint")
+	}
 
-	return nil
+	return &tokenResp, nil
 }
 
-func (c *Client) GetToken() (string, error) {
+func (c *Client) setToken(token *models.AuthToken) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = token
+	if token.ExpiresIn > 0 {
+		c.expiresAt = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	} else {
+		// No lifetime advertised; keep using the token until the API rejects it
+		c.expiresAt = time.Time{}
+	}
+}
+
+func (c *Client) refreshToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == nil {
+		return ""
+	}
+	return c.token.RefreshToken
+}
+
+// tokenValid reports whether the cached access token can still be used
+// without refreshing it first. c.mu must be held.
+func (c *Client) tokenValid() bool {
 	if c.token == nil || c.token.AccessToken == "" {
-		if err := c.GetAccessToken(); err != nil {
-			return "", err
+		return false
+	}
+	if c.expiresAt.IsZero() {
+		return true
+	}
+	return time.Now().Add(tokenRefreshSkew).Before(c.expiresAt)
+}
+
+// GetToken returns a usable access token, refreshing or logging in again as
+// needed. Concurrent callers that find the token unusable wait for a single
+// shared acquisition instead of each starting their own SSO login.
+func (c *Client) GetToken() (string, error) {
+	return c.GetTokenContext(context.Background())
+}
+
+// GetTokenContext is GetToken with a context. Cancelling ctx only stops this
+// caller from waiting; a shared acquisition keeps running for the others
+// until the base context is cancelled.
+func (c *Client) GetTokenContext(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	if c.tokenValid() {
+		token := c.token.AccessToken
+		c.mu.Unlock()
+		return token, nil
+	}
+	call := c.inflight
+	if call == nil {
+		call = &tokenCall{done: make(chan struct{})}
+		c.inflight = call
+		go c.runTokenCall(c.baseCtx, call)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.token, call.err
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (c *Client) runTokenCall(ctx context.Context, call *tokenCall) {
+	call.token, call.err = c.acquireToken(ctx)
+
+	c.mu.Lock()
+	c.inflight = nil
+	c.mu.Unlock()
+	close(call.done)
+}
+
+func (c *Client) acquireToken(ctx context.Context) (string, error) {
+	if c.store != nil && !c.storeLoaded {
+		c.storeLoaded = true
+		if token := c.loadStoredToken(); token != "" {
+			return token, nil
+		}
+	}
+
+	if c.breaker == nil {
+		return c.authenticate(ctx)
+	}
+	done, err := c.breaker.Allow()
+	if err != nil {
+		return "", err
+	}
+	token, err := c.authenticate(ctx)
+	if err != nil {
+		done(breaker.Failure)
+	} else {
+		done(breaker.Success)
+	}
+	return token, err
+}
+
+// authenticate obtains a new access token from the SSO, by refresh token if
+// possible and by full login otherwise.
+func (c *Client) authenticate(ctx context.Context) (string, error) {
+	// Prefer the refresh token over replaying the full SSO login
+	if c.refreshToken() != "" {
+		err := c.RefreshAccessTokenContext(ctx)
+		if err == nil {
+			c.saveToken()
+			return c.accessToken(), nil
 		}
+		fmt.Printf("[WARN] Token refresh failed, falling back to full login: %v\n", err)
+	}
+
+	// The session token from a previous login has most likely expired as well
+	c.sessionToken = ""
+	if err := c.GetAccessTokenContext(ctx); err != nil {
+		return "", err
+	}
+	c.saveToken()
+	return c.accessToken(), nil
+}
+
+// Login performs a full SSO login regardless of any cached or stored token
+// and saves the result to the token store. It is meant for the interactive
+// login subcommand and must not be called concurrently with GetToken.
+func (c *Client) Login() error {
+	return c.LoginContext(context.Background())
+}
+
+func (c *Client) LoginContext(ctx context.Context) error {
+	c.storeLoaded = true
+	c.sessionToken = ""
+	if err := c.GetAccessTokenContext(ctx); err != nil {
+		return err
+	}
+	c.saveToken()
+	return nil
+}
+
+// loadStoredToken restores the token cache from the store. It returns the
+// access token if it is still usable; otherwise the restored refresh token
+// (if any) is left for acquireToken to try.
+func (c *Client) loadStoredToken() string {
+	stored, err := c.store.Load()
+	if err != nil {
+		fmt.Printf("[WARN] Failed to load stored token: %v\n", err)
+		return ""
 	}
-	return c.token.AccessToken, nil
-}
\ No newline at end of file
+	if stored == nil {
+		return ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.token = &models.AuthToken{
+		AccessToken:  stored.AccessToken,
+		RefreshToken: stored.RefreshToken,
+	}
+	c.expiresAt = stored.ExpiresAt
+	if stored.ExpiresAt.IsZero() || !c.tokenValid() {
+		// Without a known expiry the stored access token cannot be trusted
+		c.token.AccessToken = ""
+		c.expiresAt = time.Time{}
+		return ""
+	}
+
+	fmt.Printf("[INFO] Restored access token from store (expires at %s)\n", stored.ExpiresAt.Format(time.RFC3339))
+	return c.token.AccessToken
+}
+
+func (c *Client) saveToken() {
+	if c.store == nil {
+		return
+	}
+
+	c.mu.Lock()
+	stored := &StoredToken{ExpiresAt: c.expiresAt}
+	if c.token != nil {
+		stored.AccessToken = c.token.AccessToken
+		stored.RefreshToken = c.token.RefreshToken
+	}
+	c.mu.Unlock()
+
+	if err := c.store.Save(stored); err != nil {
+		fmt.Printf("[WARN] Failed to save token: %v\n", err)
+	}
+}
+
+func (c *Client) accessToken() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == nil {
+		return ""
+	}
+	return c.token.AccessToken
+}
+
+// InvalidateToken discards the cached access token after the API rejected
+// it. Only the rejected token is discarded, so a caller holding a stale
+// token cannot throw away one that another caller has just obtained. The
+// refresh token is kept so the next GetToken can try it before falling
+// back to a full login.
+func (c *Client) InvalidateToken(rejected string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == nil || c.token.AccessToken != rejected {
+		return
+	}
+	token := *c.token
+	token.AccessToken = ""
+	c.token = &token
+	c.expiresAt = time.Time{}
+}
@@ -0,0 +1,222 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSSO answers the requests of a login and of token refreshes without
+// touching the network, counting each kind of call.
+type fakeSSO struct {
+	logins    atomic.Int32
+	refreshes atomic.Int32
+	tokens    atomic.Int32
+	// refreshFails makes refresh token grants fail
+	refreshFails bool
+	// loginDelay keeps a login running long enough for concurrent callers
+	// to pile up behind it
+	loginDelay time.Duration
+	// mfaCode makes the login answer with an MFA challenge, accepting only
+	// this code
+	mfaCode string
+	// mfaForm is the form of the last MFA code validation
+	mu      sync.Mutex
+	mfaForm url.Values
+}
+
+func (f *fakeSSO) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Path {
+	case "/settings.json":
+		return f.respond(http.StatusOK, nil, `{"ssoBaseUrl":"https://sso.test","ssoEndpointAuthZ":"/authorize","ssoEndpointTokens":"/token","ssoClientIdAuthZ":"client"}`)
+
+	case "/aio/api/v1/mfa/validate/full":
+		f.logins.Add(1)
+		time.Sleep(f.loginDelay)
+		if f.mfaCode != "" {
+			return f.respond(http.StatusOK, nil, `{"requires_mfa":true,"session_id":"mfa-session","token":"mfa-token"}`)
+		}
+		return f.respond(http.StatusOK, nil, `{"success":true,"access_token":"session","expires_in":300}`)
+
+	case "/aio/api/v1/mfa/validate/code":
+		body, _ := io.ReadAll(req.Body)
+		form, _ := url.ParseQuery(string(body))
+		f.mu.Lock()
+		f.mfaForm = form
+		f.mu.Unlock()
+		if form.Get("code") != f.mfaCode {
+			return f.respond(http.StatusUnauthorized, nil, `{"success":false}`)
+		}
+		return f.respond(http.StatusOK, nil, `{"success":true,"access_token":"session","expires_in":300}`)
+
+	case "/authorize":
+		header := http.Header{"Location": {"https://portal.arubainstanton.com/?code=code"}}
+		return f.respond(http.StatusFound, header, "")
+
+	case "/token":
+		body, _ := io.ReadAll(req.Body)
+		form, _ := url.ParseQuery(string(body))
+		if form.Get("grant_type") == "refresh_token" {
+			f.refreshes.Add(1)
+			if f.refreshFails {
+				return f.respond(http.StatusBadRequest, nil, `{"error":"invalid_grant"}`)
+			}
+		}
+		n := f.tokens.Add(1)
+		return f.respond(http.StatusOK, nil, fmt.Sprintf(`{"access_token":"token-%d","refresh_token":"refresh","expires_in":3600}`, n))
+	}
+	return f.respond(http.StatusNotFound, nil, "")
+}
+
+func (f *fakeSSO) respond(status int, header http.Header, body string) (*http.Response, error) {
+	if header == nil {
+		header = http.Header{}
+	}
+	return &http.Response{
+		StatusCode: status,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func newTestClient(sso *fakeSSO) *Client {
+	c := NewClient("user", "password")
+	c.httpClient.Transport = sso
+	return c
+}
+
+func TestGetTokenSingleFlight(t *testing.T) {
+	sso := &fakeSSO{loginDelay: 50 * time.Millisecond}
+	c := newTestClient(sso)
+
+	const callers = 20
+	tokens := make([]string, callers)
+	errs := make([]error, callers)
+	var wg sync.WaitGroup
+	for i := range callers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tokens[i], errs[i] = c.GetToken()
+		}()
+	}
+	wg.Wait()
+
+	for i := range callers {
+		if errs[i] != nil {
+			t.Fatalf("GetToken() error = %v", errs[i])
+		}
+		if tokens[i] != "token-1" {
+			t.Errorf("GetToken() = %q, want %q", tokens[i], "token-1")
+		}
+	}
+	if n := sso.logins.Load(); n != 1 {
+		t.Errorf("got %d logins, want 1", n)
+	}
+}
+
+func TestInvalidateToken(t *testing.T) {
+	sso := &fakeSSO{}
+	c := newTestClient(sso)
+
+	token, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	// A stale token must not discard the current one
+	c.InvalidateToken("stale")
+	got, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if got != token {
+		t.Errorf("GetToken() after invalidating a stale token = %q, want %q", got, token)
+	}
+	if n := sso.refreshes.Load(); n != 0 {
+		t.Errorf("got %d refreshes after invalidating a stale token, want 0", n)
+	}
+
+	// The current token is replaced by refreshing it
+	c.InvalidateToken(token)
+	got, err = c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if got == token {
+		t.Errorf("GetToken() after invalidating the current token returned it again")
+	}
+	if n := sso.refreshes.Load(); n != 1 {
+		t.Errorf("got %d refreshes, want 1", n)
+	}
+	if n := sso.logins.Load(); n != 1 {
+		t.Errorf("got %d logins, want 1", n)
+	}
+}
+
+func TestRefreshFallsBackToLogin(t *testing.T) {
+	sso := &fakeSSO{refreshFails: true}
+	c := newTestClient(sso)
+
+	token, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+
+	c.InvalidateToken(token)
+	got, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if got == token {
+		t.Errorf("GetToken() after a failed refresh returned the invalidated token")
+	}
+	if n := sso.refreshes.Load(); n != 1 {
+		t.Errorf("got %d refreshes, want 1", n)
+	}
+	if n := sso.logins.Load(); n != 2 {
+		t.Errorf("got %d logins, want 2", n)
+	}
+}
+
+func TestGetTokenMFA(t *testing.T) {
+	sso := &fakeSSO{mfaCode: "123456"}
+
+	c := newTestClient(sso)
+	if _, err := c.GetToken(); !errors.Is(err, ErrMFARequired) {
+		t.Errorf("GetToken() without an MFA code source error = %v, want ErrMFARequired", err)
+	}
+
+	c = newTestClient(sso)
+	c.SetMFACodeFunc(func() (string, error) { return "123456", nil })
+	token, err := c.GetToken()
+	if err != nil {
+		t.Fatalf("GetToken() error = %v", err)
+	}
+	if token == "" {
+		t.Errorf("GetToken() returned an empty token")
+	}
+
+	sso.mu.Lock()
+	form := sso.mfaForm
+	sso.mu.Unlock()
+	want := url.Values{"session_id": {"mfa-session"}, "token": {"mfa-token"}, "code": {"123456"}}
+	for key := range want {
+		if form.Get(key) != want.Get(key) {
+			t.Errorf("MFA code request %s = %q, want %q", key, form.Get(key), want.Get(key))
+		}
+	}
+
+	c = newTestClient(sso)
+	c.SetMFACodeFunc(func() (string, error) { return "000000", nil })
+	if _, err := c.GetToken(); err == nil {
+		t.Errorf("GetToken() with a wrong MFA code succeeded")
+	}
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA-1 key of the RFC 6238 test vectors.
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestGenerateTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		if got := GenerateTOTP(rfc6238Key, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("GenerateTOTP(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	// Authenticator apps show secrets grouped, in either case and unpadded
+	for _, secret := range []string{
+		"GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ",
+		"gezd gnbv gy3t qojq gezd gnbv gy3t qojq",
+	} {
+		key, err := decodeTOTPSecret(secret)
+		if err != nil {
+			t.Errorf("decodeTOTPSecret(%q) error = %v", secret, err)
+			continue
+		}
+		if string(key) != string(rfc6238Key) {
+			t.Errorf("decodeTOTPSecret(%q) = %q, want %q", secret, key, rfc6238Key)
+		}
+	}
+
+	for _, secret := range []string{"", "not base32!"} {
+		if _, err := decodeTOTPSecret(secret); err == nil {
+			t.Errorf("decodeTOTPSecret(%q) succeeded, want an error", secret)
+		}
+	}
+}
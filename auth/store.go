@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StoredToken is the part of the token cache that survives a restart.
+type StoredToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`
+}
+
+// TokenStore persists the token cache of a Client.
+type TokenStore interface {
+	// Load returns the stored token, or nil if nothing has been stored yet.
+	Load() (*StoredToken, error)
+	Save(token *StoredToken) error
+}
+
+// FileStore is a TokenStore backed by a single file readable only by the
+// owner. If a key is given the contents are encrypted with AES-GCM.
+type FileStore struct {
+	path string
+	aead cipher.AEAD
+}
+
+// NewFileStore returns a store writing to path. An empty key stores the
+// token as plain JSON; any other key is stretched to an AES-256 key.
+func NewFileStore(path, key string) (*FileStore, error) {
+	store := &FileStore{path: path}
+	if key == "" {
+		return store, nil
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	store.aead, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return store, nil
+}
+
+func (s *FileStore) Load() (*StoredToken, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read token store: %w", err)
+	}
+
+	if s.aead != nil {
+		nonceSize := s.aead.NonceSize()
+		if len(data) < nonceSize {
+			return nil, fmt.Errorf("failed to decrypt token store: data too short")
+		}
+		data, err = s.aead.Open(nil, data[:nonceSize], data[nonceSize:], nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt token store: %w", err)
+		}
+	}
+
+	var token StoredToken
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("failed to decode token store: %w", err)
+	}
+	return &token, nil
+}
+
+func (s *FileStore) Save(token *StoredToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		data = s.aead.Seal(nonce, nonce, data, nil)
+	}
+
+	// Write to a temporary file and rename it so a crash never leaves a
+	// truncated store behind. CreateTemp creates the file with mode 0600.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create token store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write token store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package auth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreRoundTrip(t *testing.T) {
+	token := &StoredToken{
+		AccessToken:  "access",
+		RefreshToken: "refresh",
+		ExpiresAt:    time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, key := range []string{"", "secret"} {
+		path := filepath.Join(t.TempDir(), "token.json")
+		store, err := NewFileStore(path, key)
+		if err != nil {
+			t.Fatalf("NewFileStore(%q) error = %v", key, err)
+		}
+
+		if got, err := store.Load(); err != nil || got != nil {
+			t.Errorf("Load() of a missing store = %v, %v, want nil, nil", got, err)
+		}
+		if err := store.Save(token); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+		got, err := store.Load()
+		if err != nil {
+			t.Fatalf("Load() error = %v", err)
+		}
+		if got.AccessToken != token.AccessToken || got.RefreshToken != token.RefreshToken || !got.ExpiresAt.Equal(token.ExpiresAt) {
+			t.Errorf("Load() with key %q = %+v, want %+v", key, got, token)
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat() error = %v", err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o600 {
+			t.Errorf("store file mode = %o, want 600", mode)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile() error = %v", err)
+		}
+		if encrypted := !bytes.Contains(data, []byte(token.RefreshToken)); encrypted != (key != "") {
+			t.Errorf("store with key %q holds the token in plain text: %v", key, !encrypted)
+		}
+	}
+}
+
+func TestFileStoreWrongKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	store, err := NewFileStore(path, "right")
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if err := store.Save(&StoredToken{AccessToken: "access"}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	wrong, err := NewFileStore(path, "wrong")
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+	if got, err := wrong.Load(); err == nil {
+		t.Errorf("Load() with the wrong key = %+v, want an error", got)
+	}
+}
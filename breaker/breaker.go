@@ -0,0 +1,178 @@
+// Package breaker implements a circuit breaker that stops calls to a
+// failing service for a while instead of hammering it.
+package breaker
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow while the breaker rejects calls.
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a Breaker, ordered by severity.
+type State int
+
+const (
+	// StateClosed lets every call through.
+	StateClosed State = iota
+	// StateHalfOpen lets a single probe call through to test the service.
+	StateHalfOpen
+	// StateOpen rejects every call until the open timeout has passed.
+	StateOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case StateClosed:
+		return "closed"
+	case StateHalfOpen:
+		return "half-open"
+	case StateOpen:
+		return "open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Outcome is the result of a call reported back to the breaker.
+type Outcome int
+
+const (
+	Success Outcome = iota
+	Failure
+	// Ignored calls neither count as success nor as failure, e.g. calls
+	// cancelled by the caller.
+	Ignored
+)
+
+// Settings configures a Breaker.
+type Settings struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker.
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before letting a probe
+	// through. It doubles every time the probe fails, up to MaxOpenTimeout.
+	OpenTimeout    time.Duration
+	MaxOpenTimeout time.Duration
+	// OnStateChange, if set, is called on every transition. It runs with the
+	// breaker locked and must not call back into it.
+	OnStateChange func(name string, from, to State)
+}
+
+// Breaker is a circuit breaker. It is safe for concurrent use.
+type Breaker struct {
+	name     string
+	settings Settings
+
+	mu          sync.Mutex
+	state       State
+	failures    int
+	openTimeout time.Duration
+	openUntil   time.Time
+	probing     bool
+}
+
+// New returns a closed breaker. The name identifies it in errors and state
+// change notifications.
+func New(name string, settings Settings) *Breaker {
+	return &Breaker{
+		name:        name,
+		settings:    settings,
+		openTimeout: settings.OpenTimeout,
+	}
+}
+
+// Name returns the name the breaker was created with.
+func (b *Breaker) Name() string {
+	return b.name
+}
+
+// State returns the current state. An open breaker whose timeout has passed
+// is still reported as open until the next call probes the service.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Allow reports whether a call may proceed. If it may, the caller must pass
+// the outcome of the call to done exactly once; otherwise the returned error
+// wraps ErrOpen.
+func (b *Breaker) Allow() (done func(Outcome), err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	probe := false
+	switch b.state {
+	case StateOpen:
+		if wait := time.Until(b.openUntil); wait > 0 {
+			return nil, fmt.Errorf("%s %w, retrying in %s", b.name, ErrOpen, wait.Round(time.Second))
+		}
+		b.setState(StateHalfOpen)
+		fallthrough
+	case StateHalfOpen:
+		if b.probing {
+			return nil, fmt.Errorf("%s %w, probe in progress", b.name, ErrOpen)
+		}
+		b.probing = true
+		probe = true
+	}
+
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() { b.record(probe, outcome) })
+	}, nil
+}
+
+func (b *Breaker) record(probe bool, outcome Outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if probe {
+		b.probing = false
+		switch outcome {
+		case Success:
+			b.failures = 0
+			b.openTimeout = b.settings.OpenTimeout
+			b.setState(StateClosed)
+		case Failure:
+			b.openTimeout = min(2*b.openTimeout, b.settings.MaxOpenTimeout)
+			b.open()
+		}
+		return
+	}
+
+	// Calls allowed before the breaker opened may finish afterwards; they
+	// must not affect the open or half-open state
+	if b.state != StateClosed {
+		return
+	}
+	switch outcome {
+	case Success:
+		b.failures = 0
+	case Failure:
+		b.failures++
+		if b.failures >= b.settings.FailureThreshold {
+			b.open()
+		}
+	}
+}
+
+func (b *Breaker) open() {
+	b.failures = 0
+	b.openUntil = time.Now().Add(b.openTimeout)
+	b.setState(StateOpen)
+}
+
+func (b *Breaker) setState(state State) {
+	if state == b.state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.settings.OnStateChange != nil {
+		b.settings.OnStateChange(b.name, from, state)
+	}
+}
@@ -0,0 +1,200 @@
+package breaker
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testSettings = Settings{
+	FailureThreshold: 3,
+	OpenTimeout:      time.Minute,
+	MaxOpenTimeout:   3 * time.Minute,
+}
+
+// expire lets the open timeout of b pass without waiting for it.
+func expire(b *Breaker) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.openUntil = time.Now()
+}
+
+// call runs one call through b with the given outcome.
+func call(t *testing.T, b *Breaker, outcome Outcome) {
+	t.Helper()
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() in state %s error = %v", b.State(), err)
+	}
+	done(outcome)
+}
+
+// open opens b by failing FailureThreshold calls.
+func open(t *testing.T, b *Breaker) {
+	t.Helper()
+	for range b.settings.FailureThreshold {
+		call(t, b, Failure)
+	}
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("state after %d failures = %s, want open", b.settings.FailureThreshold, got)
+	}
+}
+
+func TestBreakerThreshold(t *testing.T) {
+	var transitions []State
+	settings := testSettings
+	settings.OnStateChange = func(name string, from, to State) {
+		transitions = append(transitions, to)
+	}
+	b := New("api", settings)
+
+	// A success in between resets the count of consecutive failures
+	call(t, b, Failure)
+	call(t, b, Failure)
+	call(t, b, Success)
+	call(t, b, Failure)
+	call(t, b, Failure)
+	call(t, b, Ignored)
+	if got := b.State(); got != StateClosed {
+		t.Fatalf("state after non-consecutive failures = %s, want closed", got)
+	}
+
+	call(t, b, Failure)
+	if got := b.State(); got != StateOpen {
+		t.Fatalf("state after %d consecutive failures = %s, want open", settings.FailureThreshold, got)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() while open error = %v, want ErrOpen", err)
+	}
+	if len(transitions) != 1 || transitions[0] != StateOpen {
+		t.Errorf("transitions = %v, want [open]", transitions)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	b := New("api", testSettings)
+	open(t, b)
+
+	expire(b)
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() after the open timeout error = %v", err)
+	}
+	if got := b.State(); got != StateHalfOpen {
+		t.Errorf("state while probing = %s, want half-open", got)
+	}
+
+	// Only one probe at a time
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+				t.Errorf("Allow() during the probe error = %v, want ErrOpen", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	done(Success)
+	if got := b.State(); got != StateClosed {
+		t.Errorf("state after a successful probe = %s, want closed", got)
+	}
+	call(t, b, Success)
+}
+
+func TestBreakerIgnoredProbe(t *testing.T) {
+	b := New("api", testSettings)
+	open(t, b)
+
+	// A probe that says nothing about the service lets the next call probe
+	expire(b)
+	call(t, b, Ignored)
+	if got := b.State(); got != StateHalfOpen {
+		t.Errorf("state after an ignored probe = %s, want half-open", got)
+	}
+	call(t, b, Success)
+	if got := b.State(); got != StateClosed {
+		t.Errorf("state after a successful probe = %s, want closed", got)
+	}
+}
+
+func TestBreakerBackoff(t *testing.T) {
+	b := New("api", testSettings)
+	open(t, b)
+
+	for _, want := range []time.Duration{2 * time.Minute, 3 * time.Minute, 3 * time.Minute} {
+		expire(b)
+		start := time.Now()
+		call(t, b, Failure)
+		if got := b.State(); got != StateOpen {
+			t.Fatalf("state after a failed probe = %s, want open", got)
+		}
+		b.mu.Lock()
+		timeout, until := b.openTimeout, b.openUntil
+		b.mu.Unlock()
+		if timeout != want {
+			t.Errorf("open timeout after a failed probe = %s, want %s", timeout, want)
+		}
+		if until.Before(start.Add(want)) {
+			t.Errorf("open until %s, want at least %s from now", until.Sub(start), want)
+		}
+	}
+
+	// A successful probe restores the initial timeout
+	expire(b)
+	call(t, b, Success)
+	open(t, b)
+	b.mu.Lock()
+	timeout := b.openTimeout
+	b.mu.Unlock()
+	if timeout != testSettings.OpenTimeout {
+		t.Errorf("open timeout after recovering = %s, want %s", timeout, testSettings.OpenTimeout)
+	}
+}
+
+func TestBreakerStaleOutcomes(t *testing.T) {
+	b := New("api", testSettings)
+
+	// Calls allowed while closed finish after the breaker opened
+	var pending []func(Outcome)
+	for range 2 {
+		done, err := b.Allow()
+		if err != nil {
+			t.Fatalf("Allow() error = %v", err)
+		}
+		pending = append(pending, done)
+	}
+	open(t, b)
+
+	pending[0](Success)
+	if got := b.State(); got != StateOpen {
+		t.Errorf("state after a stale success = %s, want open", got)
+	}
+
+	expire(b)
+	probe, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow() after the open timeout error = %v", err)
+	}
+	pending[1](Success)
+	if got := b.State(); got != StateHalfOpen {
+		t.Errorf("state after a stale success during the probe = %s, want half-open", got)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Errorf("Allow() after a stale outcome during the probe error = %v, want ErrOpen", err)
+	}
+
+	probe(Failure)
+	if got := b.State(); got != StateOpen {
+		t.Errorf("state after a failed probe = %s, want open", got)
+	}
+
+	// Reporting an outcome twice has no further effect
+	probe(Success)
+	if got := b.State(); got != StateOpen {
+		t.Errorf("state after reporting the probe twice = %s, want open", got)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/csenet/instanton-exporter/breaker"
+)
+
+// CircuitBreakerConfig configures a circuit breaker in front of the API or
+// the SSO.
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that opens the
+	// breaker; 0 disables it
+	FailureThreshold int           `yaml:"failure_threshold"`
+	OpenTimeout      time.Duration `yaml:"open_timeout"`
+	MaxOpenTimeout   time.Duration `yaml:"max_open_timeout"`
+}
+
+// newCircuitBreaker returns a breaker reporting its state in the
+// circuit_breaker_state metric, or nil if cfg disables it.
+func newCircuitBreaker(name string, cfg CircuitBreakerConfig) *breaker.Breaker {
+	if cfg.FailureThreshold <= 0 {
+		return nil
+	}
+
+	circuitBreakerState.WithLabelValues(name).Set(float64(breaker.StateClosed))
+	return breaker.New(name, breaker.Settings{
+		FailureThreshold: cfg.FailureThreshold,
+		OpenTimeout:      cfg.OpenTimeout,
+		MaxOpenTimeout:   cfg.MaxOpenTimeout,
+		OnStateChange: func(name string, from, to breaker.State) {
+			circuitBreakerState.WithLabelValues(name).Set(float64(to))
+			log.Printf("Circuit breaker %s changed from %s to %s", name, from, to)
+		},
+	})
+}
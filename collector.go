@@ -0,0 +1,650 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/csenet/instanton-exporter/instanton"
+)
+
+var (
+	sitesTotalDesc = prometheus.NewDesc(
+		"aruba_instant_on_sites_total",
+		"Total number of sites",
+		nil, nil,
+	)
+
+	siteInfoDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_info",
+		"Site information",
+		[]string{"site_id", "site_name", "health", "status", "timezone"}, nil,
+	)
+
+	devicesTotalDesc = prometheus.NewDesc(
+		"aruba_instant_on_devices_total",
+		"Total number of devices",
+		[]string{"site_id", "site_name"}, nil,
+	)
+
+	deviceInfoDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_info",
+		"Device information",
+		[]string{"site_id", "site_name", "device_id", "device_name", "device_type", "model", "serial_number", "mac_address", "ip_address", "status", "operational_state"}, nil,
+	)
+
+	deviceUptimeDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_uptime_seconds",
+		"Device uptime in seconds",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+
+	wirelessClientsTotalDesc = prometheus.NewDesc(
+		"aruba_instant_on_wireless_clients_total",
+		"Total number of wireless clients",
+		[]string{"site_id", "site_name"}, nil,
+	)
+
+	wiredClientsTotalDesc = prometheus.NewDesc(
+		"aruba_instant_on_wired_clients_total",
+		"Total number of wired clients",
+		[]string{"site_id", "site_name"}, nil,
+	)
+
+	clientsByNetworkDesc = prometheus.NewDesc(
+		"aruba_instant_on_clients_by_network",
+		"Number of clients by network SSID",
+		[]string{"site_id", "site_name", "network_ssid"}, nil,
+	)
+
+	clientsByAPDesc = prometheus.NewDesc(
+		"aruba_instant_on_clients_by_ap",
+		"Number of clients by access point",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+
+	wiredClientsBySwitchDesc = prometheus.NewDesc(
+		"aruba_instant_on_wired_clients_by_switch",
+		"Number of wired clients by switch",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+
+	wiredClientsByPortDesc = prometheus.NewDesc(
+		"aruba_instant_on_wired_clients_by_port",
+		"Number of wired clients by switch port",
+		[]string{"site_id", "site_name", "device_id", "device_name", "port"}, nil,
+	)
+
+	wiredClientsByTypeDesc = prometheus.NewDesc(
+		"aruba_instant_on_wired_clients_by_type",
+		"Number of wired clients by client type",
+		[]string{"site_id", "site_name", "client_type"}, nil,
+	)
+
+	wiredVoiceClientsDesc = prometheus.NewDesc(
+		"aruba_instant_on_wired_voice_clients_total",
+		"Number of wired clients that are voice devices",
+		[]string{"site_id", "site_name"}, nil,
+	)
+
+	upDesc = prometheus.NewDesc(
+		"aruba_instant_on_up",
+		"Whether the last collection could reach the Instant On API (1) or not (0)",
+		nil, nil,
+	)
+
+	collectionDurationDesc = prometheus.NewDesc(
+		"aruba_instant_on_collection_duration_seconds",
+		"Duration of the last collection from the Instant On API",
+		nil, nil,
+	)
+
+	lastSuccessDesc = prometheus.NewDesc(
+		"aruba_instant_on_last_successful_collection_timestamp_seconds",
+		"Unix timestamp of the last collection that could list the sites",
+		nil, nil,
+	)
+
+	siteCollectionSuccessDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_collection_success",
+		"Whether all data of the site could be fetched in the last collection (1) or not (0)",
+		[]string{"site_id", "site_name"}, nil,
+	)
+
+	siteLastSuccessDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_last_successful_collection_timestamp_seconds",
+		"Unix timestamp of the last collection that fetched all data of the site",
+		[]string{"site_id", "site_name"}, nil,
+	)
+)
+
+// Collector gathers the Instant On metrics when Prometheus scrapes it. Every
+// scrape is served from a single snapshot of the API, so it never observes a
+// half-updated state, and it only contains series for what that snapshot
+// returned: deleted devices, renamed APs, SSIDs without clients and changed
+// info labels disappear with the next collection.
+type Collector struct {
+	// ctx bounds every collection, so shutting down cancels in-flight calls
+	ctx    context.Context
+	client *instanton.Client
+	cfg    CollectorConfig
+
+	// mu serializes collections, so concurrent scrapes share one snapshot
+	// instead of querying the API in parallel
+	mu              sync.Mutex
+	cached          *snapshot
+	cachedAt        time.Time
+	lastSuccess     time.Time
+	siteLastSuccess map[string]time.Time
+	states          *stateTracker
+	reboots         *rebootTracker
+}
+
+// snapshot is the API state as seen by one collection.
+type snapshot struct {
+	// up is false if the sites could not be listed; nothing else is set then
+	up          bool
+	duration    time.Duration
+	lastSuccess time.Time
+	sites       []siteSnapshot
+	sitesTotal  int
+	// states holds the tracked site and device states as of this snapshot
+	states map[stateKey]trackedState
+	// reboots holds the tracked device uptimes by device ID
+	reboots map[string]deviceBoot
+}
+
+type siteSnapshot struct {
+	site instanton.Site
+	// inventory, wireless and wired are nil if fetching them failed; wired
+	// is also nil unless wired clients are enabled
+	inventory   *instanton.InventoryResponse
+	wireless    *instanton.ClientSummaryResponse
+	wired       *instanton.WiredClientSummaryResponse
+	lastSuccess time.Time
+}
+
+// complete reports whether all data of the site could be fetched. Wired
+// clients are left out as long as their endpoint is unconfirmed.
+func (s *siteSnapshot) complete() bool {
+	return s.inventory != nil && s.wireless != nil
+}
+
+// NewCollector returns a collector querying client. With a positive
+// cfg.CacheTTL scrapes arriving within the TTL reuse the previous snapshot.
+// Cancelling ctx aborts any collection in progress.
+func NewCollector(ctx context.Context, client *instanton.Client, cfg CollectorConfig) *Collector {
+	return &Collector{
+		ctx:             ctx,
+		client:          client,
+		cfg:             cfg,
+		siteLastSuccess: make(map[string]time.Time),
+		states:          newStateTracker(),
+		reboots:         newRebootTracker(cfg.StateFile, cfg.BootTimeTolerance),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sitesTotalDesc
+	ch <- siteInfoDesc
+	ch <- devicesTotalDesc
+	ch <- deviceInfoDesc
+	ch <- deviceUptimeDesc
+	ch <- wirelessClientsTotalDesc
+	ch <- wiredClientsTotalDesc
+	ch <- clientsByNetworkDesc
+	ch <- clientsByAPDesc
+	ch <- wiredClientsBySwitchDesc
+	ch <- wiredClientsByPortDesc
+	ch <- wiredClientsByTypeDesc
+	ch <- wiredVoiceClientsDesc
+	ch <- upDesc
+	ch <- collectionDurationDesc
+	ch <- lastSuccessDesc
+	ch <- siteCollectionSuccessDesc
+	ch <- siteLastSuccessDesc
+	ch <- deviceUpDesc
+	ch <- deviceStatusDesc
+	ch <- deviceOperationalStateDesc
+	ch <- deviceStateTransitionsDesc
+	ch <- siteHealthDesc
+	ch <- siteStatusDesc
+	ch <- siteStateTransitionsDesc
+	ch <- wirelessClientsDesc
+	ch <- wirelessSignalDesc
+	ch <- wirelessSNRDesc
+	ch <- clientSignalDesc
+	ch <- clientSNRDesc
+	ch <- clientConnectionDurationDesc
+	ch <- clientMetricsSkippedDesc
+	ch <- deviceBootTimeDesc
+	ch <- deviceRebootsDesc
+}
+
+// Collect collects bounded by the root context and collector.timeout only;
+// scrapes served by Handler are also bounded by the scrape timeout.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.collect(c.ctx, ch)
+}
+
+func (c *Collector) collect(ctx context.Context, ch chan<- prometheus.Metric) {
+	snap := c.snapshot(ctx)
+
+	up := 0.0
+	if snap.up {
+		up = 1
+	}
+	ch <- prometheus.MustNewConstMetric(upDesc, prometheus.GaugeValue, up)
+	ch <- prometheus.MustNewConstMetric(collectionDurationDesc, prometheus.GaugeValue, snap.duration.Seconds())
+	if !snap.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, float64(snap.lastSuccess.Unix()))
+	}
+
+	if !snap.up {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(sitesTotalDesc, prometheus.GaugeValue, float64(snap.sitesTotal))
+
+	for _, s := range snap.sites {
+		collectSite(ch, s)
+		collectStates(ch, s, snap.states)
+		collectReboots(ch, s, snap.reboots)
+		collectClientBreakdown(ch, s)
+		collectSignalHistograms(ch, s)
+	}
+
+	if c.cfg.ClientMetrics {
+		// The cap applies to all sites together, filled in site order
+		remaining := c.cfg.MaxClients
+		skipped := 0
+		for _, s := range snap.sites {
+			emitted := collectClientMetrics(ch, s, remaining)
+			remaining -= emitted
+			if s.wireless != nil {
+				skipped += len(uniqueClients(s.wireless.Elements)) - emitted
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(clientMetricsSkippedDesc, prometheus.GaugeValue, float64(skipped))
+	}
+}
+
+// snapshot returns the cached snapshot if it is still within the TTL, or
+// queries the API for a new one within ctx.
+func (c *Collector) snapshot(ctx context.Context) *snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cached != nil && time.Since(c.cachedAt) < c.cfg.CacheTTL {
+		return c.cached
+	}
+
+	// Shutting down aborts the collection even if the scrape still waits
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.ctx, cancel)
+	defer stop()
+
+	if c.cfg.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.cfg.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	snap := c.fetch(ctx)
+	snap.duration = time.Since(start)
+
+	if snap.up {
+		c.lastSuccess = start
+		snap.states = c.states.update(snap)
+		snap.reboots = c.reboots.update(snap, start)
+		// Only successful snapshots are cached, so a failure is retried on
+		// the next scrape
+		c.cached = snap
+		c.cachedAt = start
+	}
+	snap.lastSuccess = c.lastSuccess
+
+	for i := range snap.sites {
+		s := &snap.sites[i]
+		if s.complete() {
+			c.siteLastSuccess[s.site.ID] = start
+		}
+		s.lastSuccess = c.siteLastSuccess[s.site.ID]
+	}
+	return snap
+}
+
+func (c *Collector) fetch(ctx context.Context) *snapshot {
+	sites, err := c.client.GetSitesContext(ctx)
+	if err != nil {
+		log.Printf("Failed to get sites: %v", err)
+		return &snapshot{}
+	}
+
+	var unique []instanton.Site
+	seen := make(map[string]bool, len(sites.Elements))
+	for _, site := range sites.Elements {
+		if !seen[site.ID] {
+			seen[site.ID] = true
+			unique = append(unique, site)
+		}
+	}
+
+	return &snapshot{
+		up:         true,
+		sitesTotal: sites.TotalCount,
+		sites:      c.fetchSites(ctx, unique),
+	}
+}
+
+// fetchSites fetches the sites on a bounded pool of workers. Sites that are
+// not done when ctx is cancelled (e.g. the collection deadline passed) are
+// returned without data.
+func (c *Collector) fetchSites(ctx context.Context, sites []instanton.Site) []siteSnapshot {
+	jobs := make(chan int)
+	snaps := make([]siteSnapshot, len(sites))
+	for i, site := range sites {
+		snaps[i] = siteSnapshot{site: site}
+	}
+
+	var wg sync.WaitGroup
+	for range min(c.cfg.Concurrency, len(sites)) {
+		wg.Go(func() {
+			for i := range jobs {
+				snaps[i] = c.fetchSite(ctx, sites[i])
+			}
+		})
+	}
+
+feed:
+	for i := range sites {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			log.Printf("Collection aborted, %d of %d sites not started: %v", len(sites)-i, len(sites), ctx.Err())
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return snaps
+}
+
+func (c *Collector) fetchSite(ctx context.Context, site instanton.Site) siteSnapshot {
+	s := siteSnapshot{site: site}
+	var err error
+
+	// Get devices for this site
+	s.inventory, err = c.client.GetInventoryContext(ctx, site.ID)
+	if err != nil {
+		log.Printf("Failed to get inventory for site %s: %v", site.Name, err)
+	}
+
+	// Get wireless clients for this site
+	s.wireless, err = c.client.GetClientSummaryContext(ctx, site.ID)
+	if err != nil {
+		log.Printf("Failed to get wireless clients for site %s: %v", site.Name, err)
+	}
+
+	// Get wired clients for this site
+	if c.cfg.WiredClients {
+		s.wired, err = c.client.GetWiredClientSummaryContext(ctx, site.ID)
+		if err != nil {
+			log.Printf("Failed to get wired clients for site %s: %v", site.Name, err)
+		}
+	}
+
+	return s
+}
+
+func collectSite(ch chan<- prometheus.Metric, s siteSnapshot) {
+	site := s.site
+
+	ch <- prometheus.MustNewConstMetric(siteInfoDesc, prometheus.GaugeValue, 1,
+		site.ID,
+		site.Name,
+		site.Health,
+		site.Status,
+		site.TimeZone,
+	)
+
+	success := 0.0
+	if s.complete() {
+		success = 1
+	}
+	ch <- prometheus.MustNewConstMetric(siteCollectionSuccessDesc, prometheus.GaugeValue, success, site.ID, site.Name)
+	if !s.lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(siteLastSuccessDesc, prometheus.GaugeValue, float64(s.lastSuccess.Unix()), site.ID, site.Name)
+	}
+
+	if s.inventory == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(devicesTotalDesc, prometheus.GaugeValue, float64(s.inventory.TotalCount), site.ID, site.Name)
+
+	devices := uniqueDevices(s.inventory.Elements)
+	for _, device := range devices {
+		ch <- prometheus.MustNewConstMetric(deviceInfoDesc, prometheus.GaugeValue, 1,
+			site.ID,
+			site.Name,
+			device.ID,
+			device.Name,
+			device.DeviceType,
+			device.Model,
+			device.SerialNumber,
+			device.MacAddress,
+			device.IPAddress,
+			device.Status,
+			device.OperationalState,
+		)
+
+		ch <- prometheus.MustNewConstMetric(deviceUptimeDesc, prometheus.GaugeValue, float64(device.UptimeInSeconds),
+			site.ID,
+			site.Name,
+			device.ID,
+			device.Name,
+		)
+	}
+
+	if s.wired != nil {
+		collectWiredClients(ch, site, devices, s.wired)
+	}
+
+	if s.wireless == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(wirelessClientsTotalDesc, prometheus.GaugeValue, float64(s.wireless.TotalCount), site.ID, site.Name)
+
+	clients := uniqueClients(s.wireless.Elements)
+
+	// Count clients by network SSID
+	networkCounts := make(map[string]int)
+	for _, client := range clients {
+		networkCounts[client.WirelessNetworkName]++
+	}
+	for ssid, count := range networkCounts {
+		ch <- prometheus.MustNewConstMetric(clientsByNetworkDesc, prometheus.GaugeValue, float64(count), site.ID, site.Name, ssid)
+	}
+
+	// Count clients by access point, including APs without any clients. APs
+	// are keyed by ID only and named after the inventory, so a renamed AP
+	// does not show up twice while client records still carry its old name.
+	type apCount struct {
+		name  string
+		count int
+	}
+	apCounts := make(map[string]*apCount)
+	for _, device := range devices {
+		if device.DeviceType == "accessPoint" {
+			apCounts[device.ID] = &apCount{name: device.Name}
+		}
+	}
+	for _, client := range clients {
+		ap, ok := apCounts[client.DeviceId]
+		if !ok {
+			ap = &apCount{name: client.DeviceName}
+			apCounts[client.DeviceId] = ap
+		}
+		ap.count++
+	}
+	for id, ap := range apCounts {
+		ch <- prometheus.MustNewConstMetric(clientsByAPDesc, prometheus.GaugeValue, float64(ap.count), site.ID, site.Name, id, ap.name)
+	}
+}
+
+func collectWiredClients(ch chan<- prometheus.Metric, site instanton.Site, devices []instanton.Device, wired *instanton.WiredClientSummaryResponse) {
+	ch <- prometheus.MustNewConstMetric(wiredClientsTotalDesc, prometheus.GaugeValue, float64(wired.TotalCount), site.ID, site.Name)
+
+	// Count clients by switch, including switches without any clients
+	type switchCount struct {
+		name  string
+		count int
+	}
+	switchCounts := make(map[string]*switchCount)
+	for _, device := range devices {
+		if device.DeviceType == "switch" {
+			switchCounts[device.ID] = &switchCount{name: device.Name}
+		}
+	}
+
+	type portKey struct {
+		deviceID string
+		port     instanton.PortID
+	}
+	portCounts := make(map[portKey]int)
+	typeCounts := make(map[string]int)
+	voiceCount := 0
+
+	for _, client := range uniqueWiredClients(wired.Elements) {
+		sw, ok := switchCounts[client.DeviceId]
+		if !ok {
+			sw = &switchCount{name: client.DeviceName}
+			switchCounts[client.DeviceId] = sw
+		}
+		sw.count++
+
+		if client.Port != "" {
+			portCounts[portKey{client.DeviceId, client.Port}]++
+		}
+		typeCounts[client.ClientType]++
+		if client.IsVoiceDevice {
+			voiceCount++
+		}
+	}
+
+	for id, sw := range switchCounts {
+		ch <- prometheus.MustNewConstMetric(wiredClientsBySwitchDesc, prometheus.GaugeValue, float64(sw.count), site.ID, site.Name, id, sw.name)
+	}
+	for key, count := range portCounts {
+		ch <- prometheus.MustNewConstMetric(wiredClientsByPortDesc, prometheus.GaugeValue, float64(count), site.ID, site.Name, key.deviceID, switchCounts[key.deviceID].name, string(key.port))
+	}
+	for clientType, count := range typeCounts {
+		ch <- prometheus.MustNewConstMetric(wiredClientsByTypeDesc, prometheus.GaugeValue, float64(count), site.ID, site.Name, clientType)
+	}
+	ch <- prometheus.MustNewConstMetric(wiredVoiceClientsDesc, prometheus.GaugeValue, float64(voiceCount), site.ID, site.Name)
+}
+
+// uniqueDevices drops repeated entries for the same device ID, which would
+// otherwise be emitted as duplicate series and fail the whole scrape.
+func uniqueDevices(devices []instanton.Device) []instanton.Device {
+	seen := make(map[string]bool, len(devices))
+	unique := make([]instanton.Device, 0, len(devices))
+	for _, device := range devices {
+		if seen[device.ID] {
+			continue
+		}
+		seen[device.ID] = true
+		unique = append(unique, device)
+	}
+	return unique
+}
+
+// uniqueWiredClients drops repeated entries for the same wired client, keyed
+// like wireless clients by MAC address or else ID, so they are not counted
+// twice.
+func uniqueWiredClients(clients []instanton.WiredClient) []instanton.WiredClient {
+	seen := make(map[string]bool, len(clients))
+	unique := make([]instanton.WiredClient, 0, len(clients))
+	for _, client := range clients {
+		key := client.MacAddress
+		if key == "" {
+			key = client.ID
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, client)
+	}
+	return unique
+}
+
+// scrapeTimeoutOffset is taken off the scrape timeout announced by
+// Prometheus, leaving time to encode and send the response.
+const scrapeTimeoutOffset = 500 * time.Millisecond
+
+// Handler serves the metrics gathered by reg together with those of the
+// collector. The collection is bound to the scrape request: it is cancelled
+// when the scraper goes away and cut short ahead of the timeout announced in
+// the X-Prometheus-Scrape-Timeout-Seconds header.
+func (c *Collector) Handler(reg prometheus.Gatherer) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		timeout, err := scrapeTimeout(r)
+		if err != nil {
+			log.Printf("Ignoring scrape timeout: %v", err)
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		// The collection is gathered first, so the API request metrics
+		// already include the requests it made
+		scrapeReg := prometheus.NewRegistry()
+		scrapeReg.MustRegister(scrapeCollector{Collector: c, ctx: ctx})
+		promhttp.HandlerFor(prometheus.Gatherers{scrapeReg, reg}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+	})
+}
+
+// scrapeTimeout returns how long a collection may take for the scrape r, or
+// 0 if the scraper did not announce a timeout.
+func scrapeTimeout(r *http.Request) (time.Duration, error) {
+	value := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds")
+	if value == "" {
+		return 0, nil
+	}
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds <= 0 {
+		return 0, fmt.Errorf("invalid X-Prometheus-Scrape-Timeout-Seconds %q", value)
+	}
+
+	timeout := time.Duration(seconds * float64(time.Second))
+	if timeout > 2*scrapeTimeoutOffset {
+		timeout -= scrapeTimeoutOffset
+	}
+	return timeout, nil
+}
+
+// scrapeCollector collects the metrics of a Collector for a single scrape,
+// bounded by ctx.
+type scrapeCollector struct {
+	*Collector
+	ctx context.Context
+}
+
+func (s scrapeCollector) Collect(ch chan<- prometheus.Metric) {
+	s.collect(s.ctx, ch)
+}
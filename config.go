@@ -0,0 +1,432 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.yaml.in/yaml/v2"
+
+	"github.com/csenet/instanton-exporter/instanton"
+)
+
+// Config holds every tunable of the exporter.
+//
+// Settings are resolved in the following order, later sources overriding
+// earlier ones:
+//
+//  1. built-in defaults
+//  2. the YAML config file (--config.file or ARUBA_CONFIG_FILE)
+//  3. environment variables (a .env file is loaded into the environment first)
+//  4. command-line flags
+//
+// Credentials and secrets can only be set through the config file or the
+// environment so they never show up in the process list.
+type Config struct {
+	Auth      AuthConfig      `yaml:"auth"`
+	API       APIConfig       `yaml:"api"`
+	Web       WebConfig       `yaml:"web"`
+	Collector CollectorConfig `yaml:"collector"`
+}
+
+type AuthConfig struct {
+	Username       string               `yaml:"username"`
+	Password       string               `yaml:"password"`
+	TokenStore     string               `yaml:"token_store"`
+	TokenStoreKey  string               `yaml:"token_store_key"`
+	TOTPSecret     string               `yaml:"totp_secret"`
+	Timeout        time.Duration        `yaml:"timeout"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+}
+
+type APIConfig struct {
+	BaseURL        string               `yaml:"base_url"`
+	Version        string               `yaml:"version"`
+	Timeout        time.Duration        `yaml:"timeout"`
+	Retry          RetryConfig          `yaml:"retry"`
+	RateLimit      RateLimitConfig      `yaml:"rate_limit"`
+	CircuitBreaker CircuitBreakerConfig `yaml:"circuit_breaker"`
+	// PageSize is the number of elements requested per page of list
+	// endpoints; 0 requests them without pagination
+	PageSize int `yaml:"page_size"`
+}
+
+// RetryConfig maps instanton.RetryPolicy to the config file.
+type RetryConfig struct {
+	MaxAttempts   int           `yaml:"max_attempts"`
+	BaseDelay     time.Duration `yaml:"base_delay"`
+	MaxDelay      time.Duration `yaml:"max_delay"`
+	NonIdempotent bool          `yaml:"non_idempotent"`
+}
+
+// RateLimitConfig maps instanton.RateLimit to the config file.
+type RateLimitConfig struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst"`
+}
+
+type WebConfig struct {
+	ListenAddresses []string `yaml:"listen_addresses"`
+	SystemdSocket   bool     `yaml:"systemd_socket"`
+	ConfigFile      string   `yaml:"config_file"`
+}
+
+type CollectorConfig struct {
+	CacheTTL    time.Duration `yaml:"cache_ttl"`
+	Concurrency int           `yaml:"concurrency"`
+	Timeout     time.Duration `yaml:"timeout"`
+	// ClientMetrics enables per-client wireless metrics for at most
+	// MaxClients clients
+	ClientMetrics bool `yaml:"client_metrics"`
+	MaxClients    int  `yaml:"max_clients"`
+	// WiredClients enables the wired client metrics, whose endpoint has not
+	// been confirmed against the portal
+	WiredClients bool `yaml:"wired_clients"`
+	// StateFile persists the device reboot counters across restarts
+	StateFile string `yaml:"state_file"`
+	// BootTimeTolerance is how much later a boot time derived from the
+	// uptime may be than the known one before it counts as a reboot
+	BootTimeTolerance time.Duration `yaml:"boot_time_tolerance"`
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Auth: AuthConfig{
+			Timeout: 30 * time.Second,
+			// Every failed acquisition may replay the password login, so
+			// back off early and for long to stay clear of account lockout
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: 2,
+				OpenTimeout:      5 * time.Minute,
+				MaxOpenTimeout:   time.Hour,
+			},
+		},
+		API: APIConfig{
+			BaseURL: instanton.DefaultBaseURL,
+			Version: instanton.DefaultAPIVersion,
+			Timeout: 30 * time.Second,
+			Retry:   RetryConfig(instanton.DefaultRetryPolicy),
+			// Off by default: any useful rate stretches collections of
+			// larger accounts beyond the default scrape timeout
+			RateLimit: RateLimitConfig{
+				Burst: 10,
+			},
+			CircuitBreaker: CircuitBreakerConfig{
+				FailureThreshold: 5,
+				OpenTimeout:      30 * time.Second,
+				MaxOpenTimeout:   5 * time.Minute,
+			},
+			PageSize: instanton.DefaultPageSize,
+		},
+		Web: WebConfig{
+			ListenAddresses: []string{defaultListenAddress},
+		},
+		Collector: CollectorConfig{
+			Concurrency: 4,
+			Timeout:     25 * time.Second,
+			MaxClients:  1000,
+			// Well above the scrape interval, so uptimes the portal
+			// reports late for a while are not taken for reboots
+			BootTimeTolerance: 10 * time.Minute,
+		},
+	}
+}
+
+// newFlagSet binds the command-line flags to cfg, using its current values
+// as defaults.
+func newFlagSet(cfg *Config, configFile *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("instanton-exporter", flag.ContinueOnError)
+	fs.StringVar(configFile, "config.file", *configFile, "Path to the YAML configuration file (env ARUBA_CONFIG_FILE).")
+	fs.StringVar(&cfg.Auth.Username, "auth.username", cfg.Auth.Username, "Aruba Instant On account email (env ARUBA_USERNAME).")
+	fs.StringVar(&cfg.Auth.TokenStore, "auth.token-store", cfg.Auth.TokenStore, "Path of the file to persist OAuth tokens in (env ARUBA_TOKEN_STORE).")
+	fs.DurationVar(&cfg.Auth.Timeout, "auth.timeout", cfg.Auth.Timeout, "HTTP timeout for SSO requests (env ARUBA_AUTH_TIMEOUT).")
+	fs.IntVar(&cfg.Auth.CircuitBreaker.FailureThreshold, "auth.circuit-breaker.failure-threshold", cfg.Auth.CircuitBreaker.FailureThreshold, "Consecutive failed token acquisitions that suspend SSO logins, 0 disables the breaker (env ARUBA_AUTH_CIRCUIT_BREAKER_FAILURE_THRESHOLD).")
+	fs.DurationVar(&cfg.Auth.CircuitBreaker.OpenTimeout, "auth.circuit-breaker.open-timeout", cfg.Auth.CircuitBreaker.OpenTimeout, "How long SSO logins are suspended before a probe, doubled after every failed probe (env ARUBA_AUTH_CIRCUIT_BREAKER_OPEN_TIMEOUT).")
+	fs.DurationVar(&cfg.Auth.CircuitBreaker.MaxOpenTimeout, "auth.circuit-breaker.max-open-timeout", cfg.Auth.CircuitBreaker.MaxOpenTimeout, "Upper bound of the SSO suspension (env ARUBA_AUTH_CIRCUIT_BREAKER_MAX_OPEN_TIMEOUT).")
+	fs.StringVar(&cfg.API.BaseURL, "api.base-url", cfg.API.BaseURL, "Base URL of the Instant On portal API (env ARUBA_API_BASE_URL).")
+	fs.StringVar(&cfg.API.Version, "api.version", cfg.API.Version, "Value of the x-ion-api-version header (env ARUBA_API_VERSION).")
+	fs.DurationVar(&cfg.API.Timeout, "api.timeout", cfg.API.Timeout, "HTTP timeout for portal API requests (env ARUBA_API_TIMEOUT).")
+	fs.IntVar(&cfg.API.Retry.MaxAttempts, "api.retry.max-attempts", cfg.API.Retry.MaxAttempts, "Total attempts per API request, 1 disables retries (env ARUBA_API_RETRY_MAX_ATTEMPTS).")
+	fs.DurationVar(&cfg.API.Retry.BaseDelay, "api.retry.base-delay", cfg.API.Retry.BaseDelay, "Backoff before the first retry, doubled for every further one (env ARUBA_API_RETRY_BASE_DELAY).")
+	fs.DurationVar(&cfg.API.Retry.MaxDelay, "api.retry.max-delay", cfg.API.Retry.MaxDelay, "Upper bound of the retry backoff; requests asked to wait longer by Retry-After are not retried (env ARUBA_API_RETRY_MAX_DELAY).")
+	fs.BoolVar(&cfg.API.Retry.NonIdempotent, "api.retry.non-idempotent", cfg.API.Retry.NonIdempotent, "Also retry non-idempotent requests such as POST (env ARUBA_API_RETRY_NON_IDEMPOTENT).")
+	fs.Float64Var(&cfg.API.RateLimit.RequestsPerSecond, "api.rate-limit", cfg.API.RateLimit.RequestsPerSecond, "Maximum API requests per second, lowered automatically while the API is throttling; 0 disables it (env ARUBA_API_RATE_LIMIT).")
+	fs.IntVar(&cfg.API.RateLimit.Burst, "api.rate-burst", cfg.API.RateLimit.Burst, "Number of API requests allowed in a burst above the rate limit (env ARUBA_API_RATE_BURST).")
+	fs.IntVar(&cfg.API.CircuitBreaker.FailureThreshold, "api.circuit-breaker.failure-threshold", cfg.API.CircuitBreaker.FailureThreshold, "Consecutive failed API requests that suspend API requests, 0 disables the breaker (env ARUBA_API_CIRCUIT_BREAKER_FAILURE_THRESHOLD).")
+	fs.DurationVar(&cfg.API.CircuitBreaker.OpenTimeout, "api.circuit-breaker.open-timeout", cfg.API.CircuitBreaker.OpenTimeout, "How long API requests are suspended before a probe, doubled after every failed probe (env ARUBA_API_CIRCUIT_BREAKER_OPEN_TIMEOUT).")
+	fs.DurationVar(&cfg.API.CircuitBreaker.MaxOpenTimeout, "api.circuit-breaker.max-open-timeout", cfg.API.CircuitBreaker.MaxOpenTimeout, "Upper bound of the API suspension (env ARUBA_API_CIRCUIT_BREAKER_MAX_OPEN_TIMEOUT).")
+	fs.IntVar(&cfg.API.PageSize, "api.page-size", cfg.API.PageSize, "Elements requested per page from list endpoints, 0 disables pagination (env ARUBA_API_PAGE_SIZE).")
+	fs.Var(&stringsFlag{values: &cfg.Web.ListenAddresses}, "web.listen-address", "Address to expose metrics on; repeatable, \"unix://\" prefix for unix sockets (env ARUBA_LISTEN_ADDRESS, comma-separated).")
+	fs.BoolVar(&cfg.Web.SystemdSocket, "web.systemd-socket", cfg.Web.SystemdSocket, "Use systemd socket activation listeners instead of port listeners (env ARUBA_SYSTEMD_SOCKET).")
+	fs.StringVar(&cfg.Web.ConfigFile, "web.config.file", cfg.Web.ConfigFile, "Path to the web configuration file enabling TLS or basic auth (env ARUBA_WEB_CONFIG_FILE).")
+	fs.DurationVar(&cfg.Collector.CacheTTL, "collector.cache-ttl", cfg.Collector.CacheTTL, "Serve scrapes arriving within this duration of the last collection from cache; 0 collects on every scrape (env ARUBA_CACHE_TTL).")
+	fs.IntVar(&cfg.Collector.Concurrency, "collector.concurrency", cfg.Collector.Concurrency, "Number of sites collected in parallel (env ARUBA_COLLECTOR_CONCURRENCY).")
+	fs.DurationVar(&cfg.Collector.Timeout, "collector.timeout", cfg.Collector.Timeout, "Upper bound for a whole collection on top of the scrape timeout; sites not done by then are reported as failed, 0 disables it (env ARUBA_COLLECTOR_TIMEOUT).")
+	fs.BoolVar(&cfg.Collector.ClientMetrics, "collector.client-metrics", cfg.Collector.ClientMetrics, "Export signal, SNR and connection duration of every wireless client (env ARUBA_COLLECTOR_CLIENT_METRICS).")
+	fs.BoolVar(&cfg.Collector.WiredClients, "collector.wired-clients", cfg.Collector.WiredClients, "Export wired client metrics from the unconfirmed wiredClientSummary endpoint (env ARUBA_COLLECTOR_WIRED_CLIENTS).")
+	fs.IntVar(&cfg.Collector.MaxClients, "collector.client-metrics.max-clients", cfg.Collector.MaxClients, "Maximum number of wireless clients with per-client metrics across all sites (env ARUBA_COLLECTOR_CLIENT_METRICS_MAX_CLIENTS).")
+	fs.StringVar(&cfg.Collector.StateFile, "collector.state-file", cfg.Collector.StateFile, "Path of the file to persist device reboot counters in; empty keeps them in memory (env ARUBA_COLLECTOR_STATE_FILE).")
+	fs.DurationVar(&cfg.Collector.BootTimeTolerance, "collector.boot-time-tolerance", cfg.Collector.BootTimeTolerance, "How much later than the known boot time a boot time derived from the uptime may be before it counts as a reboot (env ARUBA_COLLECTOR_BOOT_TIME_TOLERANCE).")
+	return fs
+}
+
+// loadConfig resolves the configuration from defaults, the config file, the
+// environment and args, in that order of precedence, and validates it.
+func loadConfig(args []string) (*Config, error) {
+	// First pass only to find the config file; flags are applied again below
+	// so they override the file and the environment
+	configFile := os.Getenv("ARUBA_CONFIG_FILE")
+	fs := newFlagSet(defaultConfig(), &configFile)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	cfg := defaultConfig()
+	if configFile != "" {
+		if err := cfg.loadFile(configFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+
+	fs = newFlagSet(cfg, &configFile)
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+func (cfg *Config) loadEnv() error {
+	stringVars := map[string]*string{
+		"ARUBA_USERNAME":        &cfg.Auth.Username,
+		"ARUBA_PASSWORD":        &cfg.Auth.Password,
+		"ARUBA_TOKEN_STORE":     &cfg.Auth.TokenStore,
+		"ARUBA_TOKEN_STORE_KEY": &cfg.Auth.TokenStoreKey,
+		"ARUBA_TOTP_SECRET":     &cfg.Auth.TOTPSecret,
+		"ARUBA_API_BASE_URL":    &cfg.API.BaseURL,
+		"ARUBA_API_VERSION":     &cfg.API.Version,
+		"ARUBA_WEB_CONFIG_FILE": &cfg.Web.ConfigFile,
+
+		"ARUBA_COLLECTOR_STATE_FILE": &cfg.Collector.StateFile,
+	}
+	for name, field := range stringVars {
+		if value, ok := os.LookupEnv(name); ok {
+			*field = value
+		}
+	}
+
+	if value, ok := os.LookupEnv("ARUBA_LISTEN_ADDRESS"); ok {
+		cfg.Web.ListenAddresses = splitList(value)
+	}
+
+	boolVars := map[string]*bool{
+		"ARUBA_SYSTEMD_SOCKET":           &cfg.Web.SystemdSocket,
+		"ARUBA_API_RETRY_NON_IDEMPOTENT": &cfg.API.Retry.NonIdempotent,
+		"ARUBA_COLLECTOR_CLIENT_METRICS": &cfg.Collector.ClientMetrics,
+		"ARUBA_COLLECTOR_WIRED_CLIENTS":  &cfg.Collector.WiredClients,
+	}
+	for name, field := range boolVars {
+		if value, ok := os.LookupEnv(name); ok {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = b
+		}
+	}
+
+	intVars := map[string]*int{
+		"ARUBA_COLLECTOR_CONCURRENCY":  &cfg.Collector.Concurrency,
+		"ARUBA_API_RETRY_MAX_ATTEMPTS": &cfg.API.Retry.MaxAttempts,
+		"ARUBA_API_RATE_BURST":         &cfg.API.RateLimit.Burst,
+		"ARUBA_API_PAGE_SIZE":          &cfg.API.PageSize,
+
+		"ARUBA_COLLECTOR_CLIENT_METRICS_MAX_CLIENTS": &cfg.Collector.MaxClients,
+
+		"ARUBA_AUTH_CIRCUIT_BREAKER_FAILURE_THRESHOLD": &cfg.Auth.CircuitBreaker.FailureThreshold,
+		"ARUBA_API_CIRCUIT_BREAKER_FAILURE_THRESHOLD":  &cfg.API.CircuitBreaker.FailureThreshold,
+	}
+	for name, field := range intVars {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = n
+		}
+	}
+
+	if value, ok := os.LookupEnv("ARUBA_API_RATE_LIMIT"); ok {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid ARUBA_API_RATE_LIMIT: %w", err)
+		}
+		cfg.API.RateLimit.RequestsPerSecond = f
+	}
+
+	durationVars := map[string]*time.Duration{
+		"ARUBA_AUTH_TIMEOUT":         &cfg.Auth.Timeout,
+		"ARUBA_API_TIMEOUT":          &cfg.API.Timeout,
+		"ARUBA_CACHE_TTL":            &cfg.Collector.CacheTTL,
+		"ARUBA_COLLECTOR_TIMEOUT":    &cfg.Collector.Timeout,
+		"ARUBA_API_RETRY_BASE_DELAY": &cfg.API.Retry.BaseDelay,
+		"ARUBA_API_RETRY_MAX_DELAY":  &cfg.API.Retry.MaxDelay,
+
+		"ARUBA_AUTH_CIRCUIT_BREAKER_OPEN_TIMEOUT":     &cfg.Auth.CircuitBreaker.OpenTimeout,
+		"ARUBA_AUTH_CIRCUIT_BREAKER_MAX_OPEN_TIMEOUT": &cfg.Auth.CircuitBreaker.MaxOpenTimeout,
+		"ARUBA_API_CIRCUIT_BREAKER_OPEN_TIMEOUT":      &cfg.API.CircuitBreaker.OpenTimeout,
+		"ARUBA_API_CIRCUIT_BREAKER_MAX_OPEN_TIMEOUT":  &cfg.API.CircuitBreaker.MaxOpenTimeout,
+		"ARUBA_COLLECTOR_BOOT_TIME_TOLERANCE":         &cfg.Collector.BootTimeTolerance,
+	}
+	for name, field := range durationVars {
+		if value, ok := os.LookupEnv(name); ok {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			*field = d
+		}
+	}
+	return nil
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if cfg.Auth.Username == "" || cfg.Auth.Password == "" {
+		errs = append(errs, errors.New("username and password are required (ARUBA_USERNAME and ARUBA_PASSWORD)"))
+	}
+	if cfg.Auth.TokenStoreKey != "" && cfg.Auth.TokenStore == "" {
+		errs = append(errs, errors.New("token store key is set but no token store path is configured"))
+	}
+	if cfg.Auth.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("auth timeout must be positive, got %s", cfg.Auth.Timeout))
+	}
+	errs = append(errs, cfg.Auth.CircuitBreaker.validate("auth")...)
+
+	if u, err := url.Parse(cfg.API.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("API base URL must be an absolute http(s) URL, got %q", cfg.API.BaseURL))
+	}
+	if cfg.API.Version == "" {
+		errs = append(errs, errors.New("API version must not be empty"))
+	}
+	if cfg.API.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("API timeout must be positive, got %s", cfg.API.Timeout))
+	}
+	if cfg.API.Retry.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("API retry max attempts must be at least 1, got %d", cfg.API.Retry.MaxAttempts))
+	}
+	if cfg.API.Retry.BaseDelay <= 0 || cfg.API.Retry.MaxDelay < cfg.API.Retry.BaseDelay {
+		errs = append(errs, fmt.Errorf("API retry delays must be positive with max delay >= base delay, got %s and %s", cfg.API.Retry.BaseDelay, cfg.API.Retry.MaxDelay))
+	}
+	if cfg.API.RateLimit.RequestsPerSecond < 0 {
+		errs = append(errs, fmt.Errorf("API rate limit must not be negative, got %g", cfg.API.RateLimit.RequestsPerSecond))
+	}
+	if cfg.API.RateLimit.RequestsPerSecond > 0 && cfg.API.RateLimit.Burst < 1 {
+		errs = append(errs, fmt.Errorf("API rate burst must be at least 1, got %d", cfg.API.RateLimit.Burst))
+	}
+	errs = append(errs, cfg.API.CircuitBreaker.validate("API")...)
+	if cfg.API.PageSize < 0 {
+		errs = append(errs, fmt.Errorf("API page size must not be negative, got %d", cfg.API.PageSize))
+	}
+
+	if len(cfg.Web.ListenAddresses) == 0 && !cfg.Web.SystemdSocket {
+		errs = append(errs, errors.New("at least one listen address is required unless systemd socket activation is used"))
+	}
+	for _, address := range cfg.Web.ListenAddresses {
+		if address == "" {
+			errs = append(errs, errors.New("listen address must not be empty"))
+		}
+	}
+	if cfg.Collector.CacheTTL < 0 {
+		errs = append(errs, fmt.Errorf("cache TTL must not be negative, got %s", cfg.Collector.CacheTTL))
+	}
+	if cfg.Collector.Concurrency < 1 {
+		errs = append(errs, fmt.Errorf("collector concurrency must be at least 1, got %d", cfg.Collector.Concurrency))
+	}
+	if cfg.Collector.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("collector timeout must not be negative, got %s", cfg.Collector.Timeout))
+	}
+	if cfg.Collector.BootTimeTolerance <= 0 {
+		errs = append(errs, fmt.Errorf("collector boot time tolerance must be positive, got %s", cfg.Collector.BootTimeTolerance))
+	}
+	if cfg.Collector.ClientMetrics && cfg.Collector.MaxClients < 1 {
+		errs = append(errs, fmt.Errorf("collector max clients must be at least 1 when client metrics are enabled, got %d", cfg.Collector.MaxClients))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func (cfg CircuitBreakerConfig) validate(name string) []error {
+	var errs []error
+	if cfg.FailureThreshold < 0 {
+		errs = append(errs, fmt.Errorf("%s circuit breaker failure threshold must not be negative, got %d", name, cfg.FailureThreshold))
+	}
+	if cfg.FailureThreshold > 0 && (cfg.OpenTimeout <= 0 || cfg.MaxOpenTimeout < cfg.OpenTimeout) {
+		errs = append(errs, fmt.Errorf("%s circuit breaker timeouts must be positive with max open timeout >= open timeout, got %s and %s", name, cfg.OpenTimeout, cfg.MaxOpenTimeout))
+	}
+	return errs
+}
+
+// stringsFlag is a repeatable flag. The first occurrence replaces the
+// default instead of appending to it.
+type stringsFlag struct {
+	values *[]string
+	set    bool
+}
+
+func (f *stringsFlag) String() string {
+	if f.values == nil {
+		return ""
+	}
+	return strings.Join(*f.values, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	if !f.set {
+		*f.values = nil
+		f.set = true
+	}
+	*f.values = append(*f.values, value)
+	return nil
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
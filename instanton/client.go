@@ -0,0 +1,318 @@
+// Package instanton is a client for the Aruba Instant On portal API.
+//
+// A Client needs a TokenSource for the OAuth access tokens, usually an
+// *auth.Client logging in with the account credentials:
+//
+//	authClient := auth.NewClient(username, password)
+//	client, err := instanton.New(instanton.WithTokenSource(authClient))
+//	if err != nil {
+//		return err
+//	}
+//	sites, err := client.GetSitesContext(ctx)
+//
+// Requests are retried on network errors, throttling and gateway errors,
+// and can optionally be rate limited and guarded by a circuit breaker.
+package instanton
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/csenet/instanton-exporter/breaker"
+)
+
+const (
+	// DefaultBaseURL is the base URL of the Instant On portal API.
+	DefaultBaseURL = "https://portal.instant-on.hpe.com/api"
+	// DefaultAPIVersion is sent in the x-ion-api-version header.
+	DefaultAPIVersion = "7"
+	// DefaultPageSize is the number of elements requested per page of list
+	// endpoints. It is 0, requesting lists in a single call, as long as the
+	// portal has not been confirmed to honour offset and limit.
+	DefaultPageSize = 0
+)
+
+// TokenSource provides the access tokens sent with every request.
+// *auth.Client implements it.
+type TokenSource interface {
+	// GetTokenContext returns a usable access token.
+	GetTokenContext(ctx context.Context) (string, error)
+	// InvalidateToken discards token after the API rejected it, so the next
+	// GetTokenContext obtains a new one.
+	InvalidateToken(token string)
+}
+
+// StaticToken is a TokenSource always returning the same token, for tools
+// that obtain their token elsewhere.
+type StaticToken string
+
+func (t StaticToken) GetTokenContext(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) InvalidateToken(token string) {}
+
+// Client is a client for the Instant On portal API. It is safe for
+// concurrent use.
+type Client struct {
+	tokens     TokenSource
+	httpClient *http.Client
+	baseURL    string
+	apiVersion string
+	retry      RetryPolicy
+	limiter    *rateLimiter
+	breaker    *breaker.Breaker
+	pageSize   int
+	observer   Observer
+	logger     *log.Logger
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTokenSource sets where access tokens come from. It is required.
+func WithTokenSource(tokens TokenSource) Option {
+	return func(c *Client) { c.tokens = tokens }
+}
+
+// WithBaseURL overrides DefaultBaseURL.
+func WithBaseURL(baseURL string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimRight(baseURL, "/") }
+}
+
+// WithAPIVersion overrides DefaultAPIVersion.
+func WithAPIVersion(version string) Option {
+	return func(c *Client) { c.apiVersion = version }
+}
+
+// WithHTTPClient sets the HTTP client used for API requests. The default
+// one times out after 30 seconds.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithRetryPolicy overrides DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// WithRateLimit makes all requests of the client share a token bucket. By
+// default requests are not rate limited.
+func WithRateLimit(limit RateLimit) Option {
+	return func(c *Client) { c.limiter = newRateLimiter(limit) }
+}
+
+// WithCircuitBreaker guards requests with b, which opens on network errors
+// and 5xx responses.
+func WithCircuitBreaker(b *breaker.Breaker) Option {
+	return func(c *Client) { c.breaker = b }
+}
+
+// WithPageSize overrides DefaultPageSize. A size of 0 requests list
+// endpoints without pagination parameters.
+func WithPageSize(size int) Option {
+	return func(c *Client) { c.pageSize = size }
+}
+
+// WithObserver reports requests, retries and rate limiting to o.
+func WithObserver(o Observer) Option {
+	return func(c *Client) { c.observer = o }
+}
+
+// WithLogger sets where retries, throttling and truncated responses are
+// logged. It defaults to the standard logger.
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// New returns a client configured by opts.
+func New(opts ...Option) (*Client, error) {
+	c := &Client{
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL:    DefaultBaseURL,
+		apiVersion: DefaultAPIVersion,
+		retry:      DefaultRetryPolicy,
+		pageSize:   DefaultPageSize,
+		observer:   NopObserver{},
+		logger:     log.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.tokens == nil {
+		return nil, errors.New("instanton: a token source is required")
+	}
+	if u, err := url.Parse(c.baseURL); err != nil || u.Host == "" {
+		return nil, fmt.Errorf("instanton: invalid base URL %q", c.baseURL)
+	}
+	if c.retry.MaxAttempts < 1 {
+		return nil, fmt.Errorf("instanton: retry max attempts must be at least 1, got %d", c.retry.MaxAttempts)
+	}
+	if c.limiter != nil {
+		c.limiter.observer = c.observer
+		c.limiter.logger = c.logger
+		c.observer.ObserveRateLimit(float64(c.limiter.max))
+	}
+	return c, nil
+}
+
+// Request is RequestContext without a context.
+func (c *Client) Request(method, endpoint string, body io.Reader) (*http.Response, error) {
+	return c.RequestContext(context.Background(), method, endpoint, body)
+}
+
+// RequestContext sends a request to the portal API. Network errors, 429 and
+// 5xx responses are retried according to the retry policy, and a request
+// rejected with 401/403 is replayed once with a new token. While the portal
+// keeps failing, the circuit breaker rejects requests without sending them.
+func (c *Client) RequestContext(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
+	if c.breaker == nil {
+		return c.request(ctx, method, endpoint, body)
+	}
+
+	done, err := c.breaker.Allow()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.request(ctx, method, endpoint, body)
+	done(breakerOutcome(ctx, resp, err))
+	return resp, err
+}
+
+// request sends a request, retrying it according to the retry policy.
+func (c *Client) request(ctx context.Context, method, endpoint string, body io.Reader) (*http.Response, error) {
+	// Buffer the body so the request can be replayed
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	for attempt := 1; ; attempt++ {
+		resp, err := c.send(ctx, method, endpoint, payload)
+
+		reason := retryReason(ctx, resp, err)
+		if reason == "" || attempt >= c.retry.MaxAttempts || !c.retry.allows(method) {
+			return resp, err
+		}
+
+		delay, ok := c.retry.delay(attempt, resp)
+		if !ok {
+			c.logger.Printf("Not retrying %s %s: the portal asked to wait longer than %s (%s)", method, endpoint, c.retry.MaxDelay, reason)
+			return resp, err
+		}
+		if sleepContext(ctx, delay) != nil {
+			// Not enough time left to retry; hand out the last outcome
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		c.observer.ObserveRetry(endpoint, reason)
+		c.logger.Printf("Retrying %s %s after %s (attempt %d of %d, %s)", method, endpoint, delay, attempt+1, c.retry.MaxAttempts, reason)
+	}
+}
+
+// send performs one attempt of a request, replaying it once with a new
+// token if the token is rejected.
+func (c *Client) send(ctx context.Context, method, endpoint string, payload []byte) (*http.Response, error) {
+	resp, token, err := c.do(ctx, method, endpoint, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	// The token was revoked or expired early; get a new one and replay once
+	c.logger.Printf("API returned status %d for %s %s, re-authenticating", resp.StatusCode, method, endpoint)
+	c.tokens.InvalidateToken(token)
+
+	resp, _, err = c.do(ctx, method, endpoint, payload)
+	return resp, err
+}
+
+// do sends a single request and also returns the access token it used, so a
+// rejected token can be invalidated precisely.
+func (c *Client) do(ctx context.Context, method, endpoint string, payload []byte) (*http.Response, string, error) {
+	token, err := c.tokens.GetTokenContext(ctx)
+	if err != nil {
+		return nil, "", &TokenError{Err: err}
+	}
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+
+	fullURL := c.baseURL + endpoint
+	req, err := http.NewRequestWithContext(ctx, method, fullURL, body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-ion-api-version", c.apiVersion)
+
+	if err := c.limiter.wait(ctx); err != nil {
+		return nil, "", err
+	}
+
+	start := time.Now()
+	resp, err := c.httpClient.Do(req)
+	status := 0
+	if err == nil {
+		status = resp.StatusCode
+	}
+	c.observer.ObserveRequest(endpoint, status, time.Since(start))
+
+	switch {
+	case status == http.StatusTooManyRequests:
+		c.limiter.throttled()
+	case status != 0:
+		c.limiter.accepted()
+	}
+
+	return resp, token, err
+}
+
+// breakerOutcome tells the circuit breaker whether a request, after all its
+// retries, shows the portal to be down.
+func breakerOutcome(ctx context.Context, resp *http.Response, err error) breaker.Outcome {
+	if err != nil {
+		// Token failures are the token source's business, and requests cut
+		// short by the caller say nothing about the portal
+		var tokenErr *TokenError
+		if errors.As(err, &tokenErr) || ctx.Err() != nil {
+			return breaker.Ignored
+		}
+		return breaker.Failure
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		// Throttling is left to the rate limiter
+		return breaker.Ignored
+	case resp.StatusCode >= 500:
+		return breaker.Failure
+	}
+	return breaker.Success
+}
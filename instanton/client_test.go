@@ -0,0 +1,251 @@
+package instanton
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testRetryPolicy retries quickly so tests don't wait for backoffs.
+var testRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   time.Millisecond,
+	MaxDelay:    10 * time.Millisecond,
+}
+
+// newTestClient returns a client of a test server running handler, using a
+// static token and testRetryPolicy unless overridden by opts.
+func newTestClient(t *testing.T, handler http.Handler, opts ...Option) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	opts = append([]Option{
+		WithTokenSource(StaticToken("token")),
+		WithBaseURL(srv.URL),
+		WithRetryPolicy(testRetryPolicy),
+		WithLogger(log.New(io.Discard, "", 0)),
+	}, opts...)
+	c, err := New(opts...)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return c
+}
+
+// failingTokens is a TokenSource that never gets a token.
+type failingTokens struct {
+	calls atomic.Int32
+}
+
+func (f *failingTokens) GetTokenContext(ctx context.Context) (string, error) {
+	f.calls.Add(1)
+	return "", errors.New("login failed")
+}
+
+func (f *failingTokens) InvalidateToken(token string) {}
+
+// rotatingTokens is a TokenSource handing out a new token after every
+// invalidation of the current one.
+type rotatingTokens struct {
+	mu          sync.Mutex
+	current     int
+	invalidated []string
+}
+
+func (r *rotatingTokens) GetTokenContext(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return fmt.Sprintf("token-%d", r.current+1), nil
+}
+
+func (r *rotatingTokens) InvalidateToken(token string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.invalidated = append(r.invalidated, token)
+	if token == fmt.Sprintf("token-%d", r.current+1) {
+		r.current++
+	}
+}
+
+// countingObserver counts the requests and retries of a client.
+type countingObserver struct {
+	NopObserver
+	requests atomic.Int32
+	retries  atomic.Int32
+}
+
+func (o *countingObserver) ObserveRequest(endpoint string, status int, duration time.Duration) {
+	o.requests.Add(1)
+}
+
+func (o *countingObserver) ObserveRetry(endpoint, reason string) {
+	o.retries.Add(1)
+}
+
+func TestRequestRetries(t *testing.T) {
+	tests := []struct {
+		name          string
+		method        string
+		nonIdempotent bool
+		statuses      []int
+		wantStatus    int
+	}{
+		{name: "GET recovers", method: http.MethodGet, statuses: []int{503, 429, 200}, wantStatus: 200},
+		{name: "GET gives up", method: http.MethodGet, statuses: []int{503, 503, 503}, wantStatus: 503},
+		{name: "GET not found", method: http.MethodGet, statuses: []int{404}, wantStatus: 404},
+		{name: "PUT recovers", method: http.MethodPut, statuses: []int{502, 200}, wantStatus: 200},
+		{name: "POST not retried", method: http.MethodPost, statuses: []int{503}, wantStatus: 503},
+		{name: "POST retried when allowed", method: http.MethodPost, nonIdempotent: true, statuses: []int{503, 200}, wantStatus: 200},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bodies []string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				mu.Lock()
+				bodies = append(bodies, string(body))
+				status := tt.statuses[len(bodies)-1]
+				mu.Unlock()
+				w.WriteHeader(status)
+			})
+
+			policy := testRetryPolicy
+			policy.NonIdempotent = tt.nonIdempotent
+			c := newTestClient(t, handler, WithRetryPolicy(policy))
+
+			resp, err := c.Request(tt.method, "/sites", strings.NewReader(`{"name":"site"}`))
+			if err != nil {
+				t.Fatalf("Request() error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(bodies) != len(tt.statuses) {
+				t.Errorf("got %d attempts, want %d", len(bodies), len(tt.statuses))
+			}
+			// Every attempt must carry the whole body, not what the
+			// previous one left of it
+			for i, body := range bodies {
+				if body != `{"name":"site"}` {
+					t.Errorf("attempt %d sent body %q", i+1, body)
+				}
+			}
+		})
+	}
+}
+
+func TestRequestNotRetried(t *testing.T) {
+	var requests atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	})
+
+	// A failing token source would repeat the SSO login on every attempt
+	tokens := &failingTokens{}
+	c := newTestClient(t, handler, WithTokenSource(tokens))
+	_, err := c.Request(http.MethodGet, "/sites", nil)
+	var tokenErr *TokenError
+	if !errors.As(err, &tokenErr) {
+		t.Errorf("Request() with a failing token source error = %v, want a *TokenError", err)
+	}
+	if n := tokens.calls.Load(); n != 1 {
+		t.Errorf("got %d token requests, want 1", n)
+	}
+
+	// A request cut short by the caller fails like a network error, but
+	// must not be attempted again
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	observer := &countingObserver{}
+	c = newTestClient(t, handler, WithObserver(observer))
+	if _, err := c.RequestContext(ctx, http.MethodGet, "/sites", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("RequestContext() with a cancelled context error = %v, want context.Canceled", err)
+	}
+	if n := observer.requests.Load(); n != 1 {
+		t.Errorf("got %d attempts with a cancelled context, want 1", n)
+	}
+	if n := observer.retries.Load(); n != 0 {
+		t.Errorf("got %d retries with a cancelled context, want 0", n)
+	}
+	if n := requests.Load(); n != 0 {
+		t.Errorf("got %d requests, want 0", n)
+	}
+}
+
+func TestRequestReplaysRejectedToken(t *testing.T) {
+	tests := []struct {
+		name            string
+		accepted        string
+		wantStatus      int
+		wantTokens      []string
+		wantInvalidated []string
+	}{
+		{
+			name:            "new token accepted",
+			accepted:        "Bearer token-2",
+			wantStatus:      http.StatusOK,
+			wantTokens:      []string{"Bearer token-1", "Bearer token-2"},
+			wantInvalidated: []string{"token-1"},
+		},
+		{
+			// The replay happens only once, and 401 is not retried
+			name:            "new token rejected",
+			wantStatus:      http.StatusUnauthorized,
+			wantTokens:      []string{"Bearer token-1", "Bearer token-2"},
+			wantInvalidated: []string{"token-1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var sent []string
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				auth := r.Header.Get("Authorization")
+				mu.Lock()
+				sent = append(sent, auth)
+				mu.Unlock()
+				if auth != tt.accepted {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			})
+			tokens := &rotatingTokens{}
+			c := newTestClient(t, handler, WithTokenSource(tokens))
+
+			resp, err := c.Request(http.MethodGet, "/sites", nil)
+			if err != nil {
+				t.Fatalf("Request() error = %v", err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(sent, tt.wantTokens) {
+				t.Errorf("sent tokens %q, want %q", sent, tt.wantTokens)
+			}
+			tokens.mu.Lock()
+			defer tokens.mu.Unlock()
+			if !slices.Equal(tokens.invalidated, tt.wantInvalidated) {
+				t.Errorf("invalidated tokens %q, want %q", tokens.invalidated, tt.wantInvalidated)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package instanton
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/csenet/instanton-exporter/breaker"
+)
+
+// ErrCircuitOpen is wrapped by the errors of requests rejected by the
+// circuit breaker without being sent.
+var ErrCircuitOpen = breaker.ErrOpen
+
+// APIError is returned when the API answers with a non-2xx status. Use
+// errors.As to inspect it:
+//
+//	var apiErr *instanton.APIError
+//	if errors.As(err, &apiErr) && apiErr.NotFound() {
+//		...
+//	}
+type APIError struct {
+	StatusCode int
+	Method     string
+	// Endpoint is the path of the request, without its query string, so
+	// errors of all pages of a list endpoint carry the same one
+	Endpoint string
+	// Query is the query string of the request, e.g. the page requested
+	Query string
+	// RequestID identifies the request in the portal's logs, if the
+	// response carried one
+	RequestID string
+	// Payload is the error document of the portal, or nil if the body was
+	// not a JSON object
+	Payload map[string]any
+	Body    string
+}
+
+// requestIDHeaders are the response headers checked for a request ID, in
+// order.
+var requestIDHeaders = []string{"X-Request-Id", "X-Correlation-Id", "X-Amzn-Requestid"}
+
+func newAPIError(resp *http.Response, method, endpoint string, body []byte) *APIError {
+	path, query, _ := strings.Cut(endpoint, "?")
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Method:     method,
+		Endpoint:   path,
+		Query:      query,
+		Body:       string(body),
+	}
+	for _, header := range requestIDHeaders {
+		if id := resp.Header.Get(header); id != "" {
+			e.RequestID = id
+			break
+		}
+	}
+	if json.Unmarshal(body, &e.Payload) != nil {
+		e.Payload = nil
+	}
+	return e
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", e.Method, e.Endpoint)
+	if e.Query != "" {
+		fmt.Fprintf(&b, "?%s", e.Query)
+	}
+	fmt.Fprintf(&b, ": API returned status %d", e.StatusCode)
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request ID %s)", e.RequestID)
+	}
+	if msg := e.Message(); msg != "" {
+		fmt.Fprintf(&b, ": %s", msg)
+	}
+	return b.String()
+}
+
+// Message returns the error message of the portal: the message field of the
+// payload if there is one, or else the raw body.
+func (e *APIError) Message() string {
+	for _, key := range []string{"message", "error_description", "error"} {
+		if msg, ok := e.Payload[key].(string); ok && msg != "" {
+			return msg
+		}
+	}
+	return strings.TrimSpace(e.Body)
+}
+
+// Unauthorized reports whether the token was rejected (401 or 403).
+func (e *APIError) Unauthorized() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// NotFound reports whether the resource does not exist (404).
+func (e *APIError) NotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// Throttled reports whether the API is rate limiting the client (429).
+func (e *APIError) Throttled() bool {
+	return e.StatusCode == http.StatusTooManyRequests
+}
+
+// ServerError reports whether the portal failed (5xx).
+func (e *APIError) ServerError() bool {
+	return e.StatusCode >= 500
+}
+
+// TokenError is returned when the TokenSource fails to provide a token.
+type TokenError struct {
+	Err error
+}
+
+func (e *TokenError) Error() string { return "failed to get access token: " + e.Err.Error() }
+func (e *TokenError) Unwrap() error { return e.Err }
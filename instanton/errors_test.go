@@ -0,0 +1,83 @@
+package instanton
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestAPIErrorFromRequest(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Correlation-Id", "corr-1")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message":"site not found"}`))
+	})
+	c := newTestClient(t, handler, WithPageSize(50))
+
+	_, err := c.GetSitesContext(context.Background())
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("GetSitesContext() error = %v, want an *APIError", err)
+	}
+	if !apiErr.NotFound() {
+		t.Errorf("NotFound() = false for status %d", apiErr.StatusCode)
+	}
+	if apiErr.Method != http.MethodGet || apiErr.Endpoint != "/sites/" || apiErr.Query != "offset=0&limit=50" {
+		t.Errorf("request = %s %s with query %q, want GET /sites/ with query %q", apiErr.Method, apiErr.Endpoint, apiErr.Query, "offset=0&limit=50")
+	}
+	want := "failed to get sites: GET /sites/?offset=0&limit=50: API returned status 404 (request ID corr-1): site not found"
+	if err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
+
+func TestAPIErrorRequestID(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    string
+	}{
+		{name: "none", want: ""},
+		{name: "request ID", headers: map[string]string{"X-Request-Id": "req"}, want: "req"},
+		{name: "correlation ID", headers: map[string]string{"X-Correlation-Id": "corr"}, want: "corr"},
+		{name: "AWS request ID", headers: map[string]string{"X-Amzn-Requestid": "amzn"}, want: "amzn"},
+		{name: "request ID first", headers: map[string]string{"X-Amzn-Requestid": "amzn", "X-Request-Id": "req"}, want: "req"},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusBadGateway, Header: http.Header{}}
+		for name, value := range tt.headers {
+			resp.Header.Set(name, value)
+		}
+		if got := newAPIError(resp, http.MethodGet, "/sites", nil).RequestID; got != tt.want {
+			t.Errorf("RequestID with %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAPIErrorMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		wantPayload bool
+		want        string
+	}{
+		{name: "message", body: `{"message":"bad site","error":"invalid"}`, wantPayload: true, want: "bad site"},
+		{name: "OAuth error", body: `{"error":"invalid_token","error_description":"token expired"}`, wantPayload: true, want: "token expired"},
+		{name: "error only", body: `{"error":"invalid_token"}`, wantPayload: true, want: "invalid_token"},
+		{name: "JSON without message", body: `{"code":42}`, wantPayload: true, want: `{"code":42}`},
+		{name: "JSON array", body: `["bad"]`, want: `["bad"]`},
+		{name: "plain text", body: "Bad Gateway\n", want: "Bad Gateway"},
+		{name: "empty", body: "", want: ""},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{StatusCode: http.StatusBadRequest, Header: http.Header{}}
+		e := newAPIError(resp, http.MethodGet, "/sites", []byte(tt.body))
+		if (e.Payload != nil) != tt.wantPayload {
+			t.Errorf("Payload for %s = %v, want one: %v", tt.name, e.Payload, tt.wantPayload)
+		}
+		if got := e.Message(); got != tt.want {
+			t.Errorf("Message() for %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package instanton
+
+import "time"
+
+// Observer is notified of the requests a Client makes, e.g. to export them
+// as metrics. Endpoints are passed as requested, including IDs and query
+// parameters. Methods may be called concurrently.
+type Observer interface {
+	// ObserveRequest is called after every HTTP request. A zero status
+	// means no response was received.
+	ObserveRequest(endpoint string, status int, duration time.Duration)
+	// ObserveRetry is called before a request is retried, with the reason:
+	// "network_error", "throttled" or "server_error".
+	ObserveRetry(endpoint, reason string)
+	// ObserveRateLimit is called whenever the rate limit changes.
+	ObserveRateLimit(requestsPerSecond float64)
+	// ObserveRateLimiterWait is called with the time each request waits
+	// for the rate limiter.
+	ObserveRateLimiterWait(wait time.Duration)
+	// ObserveTruncated is called when the elements of a list endpoint could
+	// not all be fetched, with the reason: "incomplete" if fewer elements
+	// than the total count were returned after walking all pages, or
+	// "repeated_elements" if a page repeated elements of earlier pages.
+	ObserveTruncated(endpoint, reason string)
+}
+
+// NopObserver ignores everything. Embed it to implement only part of
+// Observer.
+type NopObserver struct{}
+
+func (NopObserver) ObserveRequest(endpoint string, status int, duration time.Duration) {}
+func (NopObserver) ObserveRetry(endpoint, reason string)                               {}
+func (NopObserver) ObserveRateLimit(requestsPerSecond float64)                         {}
+func (NopObserver) ObserveRateLimiterWait(wait time.Duration)                          {}
+func (NopObserver) ObserveTruncated(endpoint, reason string)                           {}
@@ -0,0 +1,89 @@
+package instanton
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// page is the envelope of every list endpoint of the API.
+type page[T any] struct {
+	TotalCount int `json:"totalCount"`
+	Elements   []T `json:"elements"`
+}
+
+// element is an element of a list endpoint. Its key identifies it across
+// pages; elements with an empty key are never taken for repeated ones.
+type element interface {
+	key() string
+}
+
+func (s Site) key() string           { return s.ID }
+func (d Device) key() string         { return d.ID }
+func (c WirelessClient) key() string { return c.ID }
+func (c WiredClient) key() string    { return c.ID }
+
+// fetchAll walks every page of a list endpoint using offset/limit and
+// returns all elements. The portal has not been confirmed to honour these
+// parameters, so a page repeating elements of earlier pages ends the walk
+// rather than counting them twice. Such responses and responses holding
+// fewer elements than their total count are reported to the observer.
+func fetchAll[T element](ctx context.Context, c *Client, endpoint string) (*page[T], error) {
+	var all *page[T]
+	if c.pageSize <= 0 {
+		p, err := fetchPage[T](ctx, c, endpoint)
+		if err != nil {
+			return nil, err
+		}
+		all = p
+	} else {
+		all = &page[T]{}
+		seen := make(map[string]bool)
+		for offset := 0; ; {
+			p, err := fetchPage[T](ctx, c, fmt.Sprintf("%s?offset=%d&limit=%d", endpoint, offset, c.pageSize))
+			if err != nil {
+				return nil, err
+			}
+			all.TotalCount = p.TotalCount
+
+			// Duplicates within a page are left to the caller; elements of
+			// earlier pages showing up again mean the offset was ignored or
+			// the list changed while being walked
+			repeated := 0
+			for _, e := range p.Elements {
+				if seen[e.key()] {
+					repeated++
+					continue
+				}
+				all.Elements = append(all.Elements, e)
+			}
+			if repeated > 0 {
+				c.observer.ObserveTruncated(endpoint, "repeated_elements")
+				c.logger.Printf("API repeated %d elements of earlier pages at offset %d for %s, keeping %d of %d elements", repeated, offset, endpoint, len(all.Elements), all.TotalCount)
+				return all, nil
+			}
+			for _, e := range p.Elements {
+				if key := e.key(); key != "" {
+					seen[key] = true
+				}
+			}
+
+			// Pages may be shorter than requested, so only an empty page or
+			// reaching the total ends the walk
+			offset += len(p.Elements)
+			if len(p.Elements) == 0 || offset >= p.TotalCount {
+				break
+			}
+		}
+	}
+
+	if len(all.Elements) < all.TotalCount {
+		c.observer.ObserveTruncated(endpoint, "incomplete")
+		c.logger.Printf("API returned only %d of %d elements for %s", len(all.Elements), all.TotalCount, endpoint)
+	}
+	return all, nil
+}
+
+func fetchPage[T any](ctx context.Context, c *Client, endpoint string) (*page[T], error) {
+	return Do[page[T]](ctx, c, http.MethodGet, endpoint, nil)
+}
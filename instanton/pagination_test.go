@@ -0,0 +1,161 @@
+package instanton
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// truncationObserver records the truncation reasons reported by a client.
+type truncationObserver struct {
+	NopObserver
+	mu      sync.Mutex
+	reasons []string
+}
+
+func (o *truncationObserver) ObserveTruncated(endpoint, reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.reasons = append(o.reasons, reason)
+}
+
+// listHandler serves total sites, at most pageCap per response. With
+// ignoreOffset every page starts at the first site, and from emptyAt on
+// pages are empty.
+type listHandler struct {
+	total        int
+	pageCap      int
+	ignoreOffset bool
+	emptyAt      int
+
+	mu      sync.Mutex
+	queries []string
+}
+
+func (h *listHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	h.queries = append(h.queries, r.URL.RawQuery)
+	h.mu.Unlock()
+
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil {
+		limit = h.total
+	}
+	if h.ignoreOffset {
+		offset = 0
+	}
+	if h.pageCap > 0 {
+		limit = min(limit, h.pageCap)
+	}
+
+	p := page[Site]{TotalCount: h.total, Elements: []Site{}}
+	for i := offset; i < min(offset+limit, h.total); i++ {
+		if h.emptyAt > 0 && i >= h.emptyAt {
+			break
+		}
+		p.Elements = append(p.Elements, Site{ID: "s" + strconv.Itoa(i)})
+	}
+	json.NewEncoder(w).Encode(p)
+}
+
+func TestFetchAll(t *testing.T) {
+	tests := []struct {
+		name        string
+		pageSize    int
+		handler     *listHandler
+		wantSites   int
+		wantQueries []string
+		wantReasons []string
+	}{
+		{
+			name:        "unpaginated",
+			handler:     &listHandler{total: 5},
+			wantSites:   5,
+			wantQueries: []string{""},
+		},
+		{
+			name:        "unpaginated short response",
+			handler:     &listHandler{total: 5, pageCap: 3},
+			wantSites:   3,
+			wantQueries: []string{""},
+			wantReasons: []string{"incomplete"},
+		},
+		{
+			name:        "full pages",
+			pageSize:    2,
+			handler:     &listHandler{total: 5},
+			wantSites:   5,
+			wantQueries: []string{"offset=0&limit=2", "offset=2&limit=2", "offset=4&limit=2"},
+		},
+		{
+			name:        "short pages",
+			pageSize:    3,
+			handler:     &listHandler{total: 5, pageCap: 2},
+			wantSites:   5,
+			wantQueries: []string{"offset=0&limit=3", "offset=2&limit=3", "offset=4&limit=3"},
+		},
+		{
+			name:        "ignored offset",
+			pageSize:    3,
+			handler:     &listHandler{total: 5, ignoreOffset: true},
+			wantSites:   3,
+			wantQueries: []string{"offset=0&limit=3", "offset=3&limit=3"},
+			wantReasons: []string{"repeated_elements"},
+		},
+		{
+			name:        "empty page",
+			pageSize:    2,
+			handler:     &listHandler{total: 5, emptyAt: 3},
+			wantSites:   3,
+			wantQueries: []string{"offset=0&limit=2", "offset=2&limit=2", "offset=3&limit=2"},
+			wantReasons: []string{"incomplete"},
+		},
+		{
+			name:        "empty list",
+			pageSize:    2,
+			handler:     &listHandler{total: 0},
+			wantSites:   0,
+			wantQueries: []string{"offset=0&limit=2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			observer := &truncationObserver{}
+			c := newTestClient(t, tt.handler, WithPageSize(tt.pageSize), WithObserver(observer))
+
+			p, err := fetchAll[Site](context.Background(), c, "/sites")
+			if err != nil {
+				t.Fatalf("fetchAll() error = %v", err)
+			}
+			if len(p.Elements) != tt.wantSites {
+				t.Errorf("got %d sites, want %d", len(p.Elements), tt.wantSites)
+			}
+			for i, site := range p.Elements {
+				if want := "s" + strconv.Itoa(i); site.ID != want {
+					t.Errorf("site %d = %s, want %s", i, site.ID, want)
+				}
+			}
+			if p.TotalCount != tt.handler.total {
+				t.Errorf("total count = %d, want %d", p.TotalCount, tt.handler.total)
+			}
+
+			tt.handler.mu.Lock()
+			queries := tt.handler.queries
+			tt.handler.mu.Unlock()
+			if !slices.Equal(queries, tt.wantQueries) {
+				t.Errorf("queries = %q, want %q", queries, tt.wantQueries)
+			}
+			observer.mu.Lock()
+			reasons := observer.reasons
+			observer.mu.Unlock()
+			if !slices.Equal(reasons, tt.wantReasons) {
+				t.Errorf("truncation reasons = %q, want %q", reasons, tt.wantReasons)
+			}
+		})
+	}
+}
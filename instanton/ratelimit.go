@@ -0,0 +1,105 @@
+package instanton
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit caps the request rate against the Instant On API, shared by all
+// requests of a Client. While the API answers 429 the rate is lowered, and
+// it recovers once requests are accepted again.
+type RateLimit struct {
+	// RequestsPerSecond is the sustained rate; 0 disables the limiter
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// While the API is throttling, the rate is halved on every 429 down to
+// 1/minRateDivisor of the configured rate, and then recovers by
+// 1/recoverySteps of the configured rate per accepted request.
+const (
+	minRateDivisor = 16
+	recoverySteps  = 20
+)
+
+// rateLimiter is a token bucket that slows down when the API signals
+// throttling. A nil *rateLimiter lets every request through.
+type rateLimiter struct {
+	limiter  *rate.Limiter
+	max      rate.Limit
+	observer Observer
+	logger   *log.Logger
+
+	// mu serialises adjustments of the limit, which read and then set it
+	mu sync.Mutex
+}
+
+func newRateLimiter(cfg RateLimit) *rateLimiter {
+	if cfg.RequestsPerSecond <= 0 {
+		return nil
+	}
+
+	limit := rate.Limit(cfg.RequestsPerSecond)
+	return &rateLimiter{
+		limiter:  rate.NewLimiter(limit, cfg.Burst),
+		max:      limit,
+		observer: NopObserver{},
+		logger:   log.Default(),
+	}
+}
+
+// wait blocks until the next request may be sent. It fails without waiting
+// if ctx would expire first.
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	r := l.limiter.Reserve()
+	delay := r.Delay()
+	l.observer.ObserveRateLimiterWait(delay)
+	if err := sleepContext(ctx, delay); err != nil {
+		r.Cancel()
+		return err
+	}
+	return nil
+}
+
+// throttled halves the rate after the API answered 429.
+func (l *rateLimiter) throttled() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	limit := max(l.limiter.Limit()/2, l.max/minRateDivisor)
+	if limit == l.limiter.Limit() {
+		return
+	}
+	l.limiter.SetLimit(limit)
+	l.observer.ObserveRateLimit(float64(limit))
+	l.logger.Printf("API is throttling requests, slowing down to %.2f requests/s", float64(limit))
+}
+
+// accepted lets the rate recover towards the configured one after a request
+// that was not throttled.
+func (l *rateLimiter) accepted() {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.limiter.Limit() >= l.max {
+		return
+	}
+	limit := min(l.limiter.Limit()+l.max/recoverySteps, l.max)
+	l.limiter.SetLimit(limit)
+	l.observer.ObserveRateLimit(float64(limit))
+}
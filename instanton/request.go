@@ -0,0 +1,36 @@
+package instanton
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Do sends a request like RequestContext and decodes the JSON body of a 2xx
+// response into a T. Any other status is returned as an *APIError.
+func Do[T any](ctx context.Context, c *Client, method, endpoint string, body io.Reader) (*T, error) {
+	resp, err := c.RequestContext(ctx, method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, newAPIError(resp, method, endpoint, data)
+	}
+
+	var result T
+	if len(data) == 0 {
+		return &result, nil
+	}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse response: %w", err)
+	}
+	return &result, nil
+}
@@ -0,0 +1,122 @@
+package instanton
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests to the Instant On API are retried.
+// Network errors, 429 and 500/502/503/504 responses are retried with a
+// jittered exponential backoff, or after the delay of a Retry-After header.
+// A response asking to wait longer than MaxDelay is not retried.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first one
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// NonIdempotent also retries requests such as POST, which may then be
+	// applied twice
+	NonIdempotent bool
+}
+
+// DefaultRetryPolicy is the retry policy of a Client unless overridden by
+// WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    10 * time.Second,
+}
+
+// retryReason returns why the outcome of an attempt is worth retrying, or ""
+// if it is final. HTTP client timeouts are retried, but not requests whose
+// ctx is done.
+func retryReason(ctx context.Context, resp *http.Response, err error) string {
+	if err != nil {
+		// Token failures are never retried here, since each retry could
+		// trigger another full SSO login
+		var tokenErr *TokenError
+		if errors.As(err, &tokenErr) || ctx.Err() != nil {
+			return ""
+		}
+		return "network_error"
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		return "throttled"
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return "server_error"
+	}
+	return ""
+}
+
+func (p RetryPolicy) allows(method string) bool {
+	if p.NonIdempotent {
+		return true
+	}
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// delay returns how long to wait before the next attempt: the server's
+// Retry-After if it sent one, or else a jittered exponential backoff. It
+// returns false if the Retry-After exceeds MaxDelay, so the request should
+// not be retried.
+func (p RetryPolicy) delay(attempt int, resp *http.Response) (time.Duration, bool) {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			return d, d <= p.MaxDelay
+		}
+	}
+
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff > p.MaxDelay || backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	// Equal jitter: at least half the backoff, so retries never bunch up at zero
+	half := backoff / 2
+	return half + rand.N(half+1), true
+}
+
+// parseRetryAfter parses a Retry-After header given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0), true
+	}
+	return 0, false
+}
+
+// sleepContext waits for d, returning early with an error if ctx is done or
+// its deadline would pass before d elapses.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return context.DeadlineExceeded
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
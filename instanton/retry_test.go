@@ -0,0 +1,128 @@
+package instanton
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		min   time.Duration
+		max   time.Duration
+		ok    bool
+	}{
+		{value: "", ok: false},
+		{value: "0", ok: true},
+		{value: "120", min: 2 * time.Minute, max: 2 * time.Minute, ok: true},
+		{value: "-1", ok: false},
+		{value: "soon", ok: false},
+		// HTTP dates are relative to now, which moves on while parsing
+		{value: time.Now().Add(time.Minute).UTC().Format(http.TimeFormat), min: 58 * time.Second, max: time.Minute, ok: true},
+		{value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), ok: true},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.value)
+		if ok != tt.ok || got < tt.min || got > tt.max {
+			t.Errorf("parseRetryAfter(%q) = %s, %v, want [%s, %s], %v", tt.value, got, ok, tt.min, tt.max, tt.ok)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	tests := []struct {
+		name       string
+		attempt    int
+		retryAfter string
+		min        time.Duration
+		max        time.Duration
+		ok         bool
+	}{
+		{name: "first backoff", attempt: 1, min: 500 * time.Millisecond, max: time.Second, ok: true},
+		{name: "third backoff", attempt: 3, min: 2 * time.Second, max: 4 * time.Second, ok: true},
+		{name: "clamped backoff", attempt: 5, min: 5 * time.Second, max: 10 * time.Second, ok: true},
+		{name: "overflowing backoff", attempt: 100, min: 5 * time.Second, max: 10 * time.Second, ok: true},
+		{name: "retry-after", attempt: 1, retryAfter: "7", min: 7 * time.Second, max: 7 * time.Second, ok: true},
+		{name: "retry-after at max delay", attempt: 1, retryAfter: "10", min: 10 * time.Second, max: 10 * time.Second, ok: true},
+		{name: "retry-after beyond max delay", attempt: 1, retryAfter: "3600", min: time.Hour, max: time.Hour, ok: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+			// The jitter is random, so sample it
+			for range 100 {
+				got, ok := policy.delay(tt.attempt, resp)
+				if ok != tt.ok || got < tt.min || got > tt.max {
+					t.Fatalf("delay(%d) = %s, %v, want [%s, %s], %v", tt.attempt, got, ok, tt.min, tt.max, tt.ok)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAllows(t *testing.T) {
+	tests := []struct {
+		method        string
+		nonIdempotent bool
+		want          bool
+	}{
+		{http.MethodGet, false, true},
+		{http.MethodHead, false, true},
+		{http.MethodPut, false, true},
+		{http.MethodDelete, false, true},
+		{http.MethodPost, false, false},
+		{http.MethodPatch, false, false},
+		{http.MethodPost, true, true},
+		{http.MethodPatch, true, true},
+	}
+	for _, tt := range tests {
+		policy := RetryPolicy{NonIdempotent: tt.nonIdempotent}
+		if got := policy.allows(tt.method); got != tt.want {
+			t.Errorf("allows(%s) with NonIdempotent %v = %v, want %v", tt.method, tt.nonIdempotent, got, tt.want)
+		}
+	}
+}
+
+func TestRetryReason(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		status int
+		err    error
+		want   string
+	}{
+		{name: "network error", err: errors.New("connection reset"), want: "network_error"},
+		{name: "token error", err: &TokenError{Err: errors.New("login failed")}, want: ""},
+		{name: "cancelled context", ctx: cancelled, err: context.Canceled, want: ""},
+		{name: "throttled", status: http.StatusTooManyRequests, want: "throttled"},
+		{name: "service unavailable", status: http.StatusServiceUnavailable, want: "server_error"},
+		{name: "gateway timeout", status: http.StatusGatewayTimeout, want: "server_error"},
+		{name: "not implemented", status: http.StatusNotImplemented, want: ""},
+		{name: "not found", status: http.StatusNotFound, want: ""},
+		{name: "ok", status: http.StatusOK, want: ""},
+	}
+	for _, tt := range tests {
+		ctx := tt.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		var resp *http.Response
+		if tt.err == nil {
+			resp = &http.Response{StatusCode: tt.status}
+		}
+		if got := retryReason(ctx, resp, tt.err); got != tt.want {
+			t.Errorf("retryReason() for %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package instanton
+
+import (
+	"context"
+	"fmt"
+)
+
+// GetSites lists the sites of the account.
+func (c *Client) GetSites() (*SitesResponse, error) {
+	return c.GetSitesContext(context.Background())
+}
+
+func (c *Client) GetSitesContext(ctx context.Context) (*SitesResponse, error) {
+	p, err := fetchAll[Site](ctx, c, "/sites/")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sites: %w", err)
+	}
+	return &SitesResponse{TotalCount: p.TotalCount, Elements: p.Elements}, nil
+}
+
+// GetInventory lists the devices of a site.
+func (c *Client) GetInventory(siteID string) (*InventoryResponse, error) {
+	return c.GetInventoryContext(context.Background(), siteID)
+}
+
+func (c *Client) GetInventoryContext(ctx context.Context, siteID string) (*InventoryResponse, error) {
+	p, err := fetchAll[Device](ctx, c, "/sites/"+siteID+"/inventory")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get inventory: %w", err)
+	}
+	return &InventoryResponse{TotalCount: p.TotalCount, Elements: p.Elements}, nil
+}
+
+// GetClientSummary lists the wireless clients of a site.
+func (c *Client) GetClientSummary(siteID string) (*ClientSummaryResponse, error) {
+	return c.GetClientSummaryContext(context.Background(), siteID)
+}
+
+func (c *Client) GetClientSummaryContext(ctx context.Context, siteID string) (*ClientSummaryResponse, error) {
+	p, err := fetchAll[WirelessClient](ctx, c, "/sites/"+siteID+"/clientSummary")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get client summary: %w", err)
+	}
+	return &ClientSummaryResponse{TotalCount: p.TotalCount, Elements: p.Elements}, nil
+}
+
+// GetWiredClientSummary lists the wired clients of a site.
+//
+// The endpoint has not been confirmed against the portal, which has been
+// seen answering 404 for it. Such errors are returned as they are, so they
+// are not mistaken for a site without wired clients.
+func (c *Client) GetWiredClientSummary(siteID string) (*WiredClientSummaryResponse, error) {
+	return c.GetWiredClientSummaryContext(context.Background(), siteID)
+}
+
+func (c *Client) GetWiredClientSummaryContext(ctx context.Context, siteID string) (*WiredClientSummaryResponse, error) {
+	p, err := fetchAll[WiredClient](ctx, c, "/sites/"+siteID+"/wiredClientSummary")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get wired client summary: %w", err)
+	}
+	return &WiredClientSummaryResponse{TotalCount: p.TotalCount, Elements: p.Elements}, nil
+}
@@ -0,0 +1,100 @@
+package instanton
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+type Site struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Health   string `json:"health"`
+	Status   string `json:"status"`
+	TimeZone string `json:"timezoneIana"`
+}
+
+type Device struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	DeviceType       string `json:"deviceType"`
+	Model            string `json:"model"`
+	SerialNumber     string `json:"serialNumber"`
+	MacAddress       string `json:"macAddress"`
+	IPAddress        string `json:"ipAddress"`
+	Status           string `json:"status"`
+	OperationalState string `json:"operationalState"`
+	UptimeInSeconds  int    `json:"uptimeInSeconds"`
+}
+
+type InventoryResponse struct {
+	TotalCount int      `json:"totalCount"`
+	Elements   []Device `json:"elements"`
+}
+
+type WirelessClient struct {
+	ID                          string `json:"id"`
+	Name                        string `json:"name"`
+	HostName                    string `json:"hostName"`
+	ClientType                  string `json:"clientType"`
+	WirelessNetworkName         string `json:"wirelessNetworkName"`
+	WirelessNetworkId           string `json:"wirelessNetworkId"`
+	IPAddress                   string `json:"ipAddress"`
+	MacAddress                  string `json:"macAddress"`
+	DeviceName                  string `json:"deviceName"`
+	DeviceId                    string `json:"deviceId"`
+	ConnectionDurationInSeconds int    `json:"connectionDurationInSeconds"`
+	Health                      string `json:"health"`
+	Status                      string `json:"status"`
+	WirelessBand                string `json:"wirelessBand"`
+	SignalQuality               string `json:"signalQuality"`
+	SignalInDbm                 int    `json:"signalInDbm"`
+	SnrInDb                     int    `json:"snrInDb"`
+}
+
+// WiredClient is a client connected to a switch. The fields linking it to its
+// switch and port (deviceId, deviceName, portNumber) are assumed to match the
+// wireless client fields and have not been confirmed against the portal.
+type WiredClient struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	MacAddress    string `json:"macAddress"`
+	ClientType    string `json:"clientType"`
+	IsVoiceDevice bool   `json:"isVoiceDevice"`
+	IPAddress     string `json:"ipAddress"`
+	DeviceId      string `json:"deviceId"`
+	DeviceName    string `json:"deviceName"`
+	Port          PortID `json:"portNumber"`
+}
+
+// PortID is a switch port identifier, which the API sends either as a number
+// or as a string.
+type PortID string
+
+func (p *PortID) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*p = PortID(number)
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("invalid port: %s", string(data))
+	}
+	*p = PortID(text)
+	return nil
+}
+
+type ClientSummaryResponse struct {
+	TotalCount int              `json:"totalCount"`
+	Elements   []WirelessClient `json:"elements"`
+}
+
+type WiredClientSummaryResponse struct {
+	TotalCount int           `json:"totalCount"`
+	Elements   []WiredClient `json:"elements"`
+}
+
+type SitesResponse struct {
+	TotalCount int    `json:"totalCount"`
+	Elements   []Site `json:"elements"`
+}
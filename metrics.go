@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics about the exporter's own requests to the Instant On API. They are
+// updated as requests happen rather than per scrape.
+var (
+	apiRequestsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aruba_instant_on_api_requests_total",
+			Help: "Total number of requests to the Instant On API by endpoint and HTTP status code",
+		},
+		[]string{"endpoint", "code"},
+	)
+
+	apiRequestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "aruba_instant_on_api_request_duration_seconds",
+			Help:    "Latency of requests to the Instant On API by endpoint",
+			Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+		},
+		[]string{"endpoint"},
+	)
+
+	apiRetriesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aruba_instant_on_api_retries_total",
+			Help: "Total number of retried requests to the Instant On API by endpoint and reason",
+		},
+		[]string{"endpoint", "reason"},
+	)
+
+	apiRateLimit = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aruba_instant_on_api_rate_limit_requests_per_second",
+			Help: "Current request rate allowed by the client-side rate limiter, lowered while the API is throttling",
+		},
+	)
+
+	apiRateLimiterWait = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "aruba_instant_on_api_rate_limiter_wait_seconds",
+			Help: "Time the most recent request to the Instant On API had to wait for the rate limiter",
+		},
+	)
+
+	apiTruncatedResponsesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "aruba_instant_on_api_truncated_responses_total",
+			Help: "Total number of list responses from the Instant On API whose elements could not all be fetched, by reason",
+		},
+		[]string{"endpoint", "reason"},
+	)
+
+	circuitBreakerState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "aruba_instant_on_circuit_breaker_state",
+			Help: "State of the circuit breakers in front of the Instant On API and SSO (0 = closed, 1 = half-open, 2 = open)",
+		},
+		[]string{"breaker"},
+	)
+)
+
+// apiObserver exports what the API client reports as the metrics above.
+type apiObserver struct{}
+
+// ObserveRequest records one request. A zero status means the request
+// failed before a response was received.
+func (apiObserver) ObserveRequest(endpoint string, status int, duration time.Duration) {
+	code := "error"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+
+	label := endpointLabel(endpoint)
+	apiRequestsTotal.WithLabelValues(label, code).Inc()
+	apiRequestDuration.WithLabelValues(label).Observe(duration.Seconds())
+}
+
+func (apiObserver) ObserveRetry(endpoint, reason string) {
+	apiRetriesTotal.WithLabelValues(endpointLabel(endpoint), reason).Inc()
+}
+
+func (apiObserver) ObserveRateLimit(requestsPerSecond float64) {
+	apiRateLimit.Set(requestsPerSecond)
+}
+
+func (apiObserver) ObserveRateLimiterWait(wait time.Duration) {
+	apiRateLimiterWait.Set(wait.Seconds())
+}
+
+func (apiObserver) ObserveTruncated(endpoint, reason string) {
+	apiTruncatedResponsesTotal.WithLabelValues(endpointLabel(endpoint), reason).Inc()
+}
+
+// endpointLabel replaces IDs in endpoint with placeholders so the label has
+// one value per API endpoint rather than per site.
+func endpointLabel(endpoint string) string {
+	path, _, _ := strings.Cut(endpoint, "?")
+	parts := strings.Split(path, "/")
+	for i := 1; i < len(parts); i++ {
+		if parts[i-1] == "sites" && parts[i] != "" {
+			parts[i] = "{siteId}"
+		}
+	}
+	return strings.Join(parts, "/")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deviceBootTimeDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_boot_timestamp_seconds",
+		"Unix timestamp of the last boot of the device, derived from its uptime",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+
+	deviceRebootsDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_reboots_total",
+		"Number of reboots of the device detected from its uptime",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+)
+
+// deviceBoot is what is remembered about the uptime of one device. It is
+// persisted as JSON in the collector state file.
+type deviceBoot struct {
+	SiteID string `json:"site_id"`
+	// Uptime is the uptime reported at ObservedAt
+	Uptime     int       `json:"uptime_seconds"`
+	ObservedAt time.Time `json:"observed_at"`
+	// BootTime is the earliest boot time derived from the uptimes reported
+	// since the last reboot, as the portal may report stale uptimes
+	BootTime time.Time `json:"boot_time"`
+	Reboots  int       `json:"reboots"`
+
+	generation uint64
+}
+
+// rebootState is the layout of the collector state file.
+type rebootState struct {
+	Devices map[string]*deviceBoot `json:"devices"`
+}
+
+// rebootTracker follows the uptime of every device across collections to
+// detect reboots. If it has a path, its state is saved there after every
+// collection and loaded on start, so the reboot counters survive restarts.
+// It is only used under Collector.mu.
+type rebootTracker struct {
+	path string
+	// tolerance is how much later than the known boot time a boot time
+	// derived from the uptime may be before it counts as a reboot, even if
+	// the uptime already exceeds the last one seen, e.g. after the exporter
+	// was down. It absorbs uptimes that the portal reports late.
+	tolerance  time.Duration
+	devices    map[string]*deviceBoot
+	generation uint64
+}
+
+// newRebootTracker returns a tracker persisting to path, or keeping its
+// state in memory only if path is empty. A state file that cannot be read is
+// logged and started over.
+func newRebootTracker(path string, tolerance time.Duration) *rebootTracker {
+	t := &rebootTracker{path: path, tolerance: tolerance, devices: make(map[string]*deviceBoot)}
+	if path == "" {
+		return t
+	}
+	if err := t.load(); err != nil {
+		log.Printf("Failed to load collector state, reboot counters start over: %v", err)
+	}
+	return t
+}
+
+func (t *rebootTracker) load() error {
+	data, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read state file: %w", err)
+	}
+
+	var state rebootState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to decode state file: %w", err)
+	}
+	for id, device := range state.Devices {
+		if device != nil {
+			t.devices[id] = device
+		}
+	}
+	return nil
+}
+
+// save writes the state to a temporary file and renames it, so a crash never
+// leaves a truncated state file behind.
+func (t *rebootTracker) save() error {
+	data, err := json.Marshal(rebootState{Devices: t.devices})
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(t.path), filepath.Base(t.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), t.path); err != nil {
+		return fmt.Errorf("failed to write state file: %w", err)
+	}
+	return nil
+}
+
+// update records the uptimes of a fresh snapshot taken at now and returns a
+// copy of the tracked devices for it. Devices that are gone are forgotten,
+// except for those of sites whose inventory could not be fetched this time.
+func (t *rebootTracker) update(snap *snapshot, now time.Time) map[string]deviceBoot {
+	t.generation++
+
+	keepSites := make(map[string]bool)
+	for _, s := range snap.sites {
+		if s.inventory == nil {
+			keepSites[s.site.ID] = true
+			continue
+		}
+		for _, device := range uniqueDevices(s.inventory.Elements) {
+			t.observe(s.site.ID, device.ID, device.UptimeInSeconds, now)
+		}
+	}
+
+	devices := make(map[string]deviceBoot, len(t.devices))
+	for id, device := range t.devices {
+		if device.generation != t.generation && !keepSites[device.SiteID] {
+			delete(t.devices, id)
+			continue
+		}
+		devices[id] = *device
+	}
+
+	if t.path != "" {
+		if err := t.save(); err != nil {
+			log.Printf("Failed to save collector state: %v", err)
+		}
+	}
+	return devices
+}
+
+// observe records the uptime of a device. A reboot is counted if the uptime
+// went backwards, or if the boot time it yields is later than the known one
+// by more than the tolerance.
+func (t *rebootTracker) observe(siteID, deviceID string, uptime int, now time.Time) {
+	device, ok := t.devices[deviceID]
+	if !ok {
+		device = &deviceBoot{}
+		t.devices[deviceID] = device
+	}
+	device.SiteID = siteID
+	device.generation = t.generation
+
+	// Devices that are down report no uptime; keep the last one so the
+	// reboot is detected once they are back
+	if uptime <= 0 {
+		return
+	}
+
+	bootTime := now.Add(-time.Duration(uptime) * time.Second).Truncate(time.Second)
+	switch {
+	case device.ObservedAt.IsZero():
+		device.BootTime = bootTime
+	case uptime < device.Uptime || bootTime.After(device.BootTime.Add(t.tolerance)):
+		device.Reboots++
+		device.BootTime = bootTime
+	case bootTime.Before(device.BootTime):
+		device.BootTime = bootTime
+	}
+	device.Uptime = uptime
+	device.ObservedAt = now
+}
+
+// collectReboots emits the boot time and reboot counter of the devices of a
+// site.
+func collectReboots(ch chan<- prometheus.Metric, s siteSnapshot, devices map[string]deviceBoot) {
+	if s.inventory == nil {
+		return
+	}
+
+	site := s.site
+	for _, device := range uniqueDevices(s.inventory.Elements) {
+		boot, ok := devices[device.ID]
+		if !ok {
+			continue
+		}
+		if !boot.BootTime.IsZero() {
+			ch <- prometheus.MustNewConstMetric(deviceBootTimeDesc, prometheus.GaugeValue, float64(boot.BootTime.Unix()),
+				site.ID, site.Name, device.ID, device.Name)
+		}
+		ch <- prometheus.MustNewConstMetric(deviceRebootsDesc, prometheus.CounterValue, float64(boot.Reboots),
+			site.ID, site.Name, device.ID, device.Name)
+	}
+}
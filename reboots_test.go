@@ -0,0 +1,145 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/csenet/instanton-exporter/instanton"
+)
+
+const testBootTimeTolerance = 10 * time.Minute
+
+// uptimeSnapshot returns a snapshot of one site with a single device
+// reporting uptime.
+func uptimeSnapshot(uptime int) *snapshot {
+	return testSnapshot(instanton.Site{ID: "s1"}, []instanton.Device{{ID: "d1", UptimeInSeconds: uptime}})
+}
+
+func TestRebootTrackerUptimeBackwards(t *testing.T) {
+	tracker := newRebootTracker("", testBootTimeTolerance)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	devices := tracker.update(uptimeSnapshot(3600), start)
+	if got, want := devices["d1"].BootTime, start.Add(-time.Hour); !got.Equal(want) {
+		t.Errorf("boot time = %s, want %s", got, want)
+	}
+
+	devices = tracker.update(uptimeSnapshot(3660), start.Add(time.Minute))
+	if got := devices["d1"].Reboots; got != 0 {
+		t.Errorf("reboots after a growing uptime = %d, want 0", got)
+	}
+
+	now := start.Add(2 * time.Minute)
+	devices = tracker.update(uptimeSnapshot(30), now)
+	if got := devices["d1"].Reboots; got != 1 {
+		t.Errorf("reboots after the uptime went backwards = %d, want 1", got)
+	}
+	if got, want := devices["d1"].BootTime, now.Add(-30*time.Second); !got.Equal(want) {
+		t.Errorf("boot time after the reboot = %s, want %s", got, want)
+	}
+}
+
+func TestRebootTrackerStaleUptime(t *testing.T) {
+	tracker := newRebootTracker("", testBootTimeTolerance)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.update(uptimeSnapshot(3600), start)
+	tracker.update(uptimeSnapshot(3660), start.Add(time.Minute))
+
+	// An uptime reported late for a couple of cycles yields a later boot
+	// time within the tolerance
+	devices := tracker.update(uptimeSnapshot(3660), start.Add(3*time.Minute))
+	if got := devices["d1"].Reboots; got != 0 {
+		t.Errorf("reboots after a stale uptime = %d, want 0", got)
+	}
+	if got, want := devices["d1"].BootTime, start.Add(-time.Hour); !got.Equal(want) {
+		t.Errorf("boot time = %s, want the earliest %s", got, want)
+	}
+}
+
+func TestRebootTrackerLongStaleUptime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	tracker := newRebootTracker(path, testBootTimeTolerance)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.update(uptimeSnapshot(3600), start)
+
+	// The tolerance does not depend on the collection cycle, so an uptime
+	// stuck for many cycles is no reboot, neither right after a restart
+	for i := 1; i <= 8; i++ {
+		if i == 4 {
+			tracker = newRebootTracker(path, testBootTimeTolerance)
+		}
+		devices := tracker.update(uptimeSnapshot(3600), start.Add(time.Duration(i)*time.Minute))
+		if got := devices["d1"].Reboots; got != 0 {
+			t.Fatalf("reboots after a stale uptime for %d cycles = %d, want 0", i, got)
+		}
+	}
+
+	// Beyond the tolerance it is taken for a reboot
+	devices := tracker.update(uptimeSnapshot(3600), start.Add(testBootTimeTolerance+time.Minute))
+	if got := devices["d1"].Reboots; got != 1 {
+		t.Errorf("reboots after a stale uptime beyond the tolerance = %d, want 1", got)
+	}
+}
+
+func TestRebootTrackerDeviceDown(t *testing.T) {
+	tracker := newRebootTracker("", testBootTimeTolerance)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker.update(uptimeSnapshot(3600), start)
+	devices := tracker.update(uptimeSnapshot(0), start.Add(time.Minute))
+	if got := devices["d1"].Reboots; got != 0 {
+		t.Errorf("reboots while the device is down = %d, want 0", got)
+	}
+
+	devices = tracker.update(uptimeSnapshot(20), start.Add(2*time.Minute))
+	if got := devices["d1"].Reboots; got != 1 {
+		t.Errorf("reboots once the device is back = %d, want 1", got)
+	}
+	devices = tracker.update(uptimeSnapshot(80), start.Add(3*time.Minute))
+	if got := devices["d1"].Reboots; got != 1 {
+		t.Errorf("reboots after the device kept running = %d, want 1", got)
+	}
+}
+
+func TestRebootTrackerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tracker := newRebootTracker(path, testBootTimeTolerance)
+	tracker.update(uptimeSnapshot(3600), start)
+	tracker.update(uptimeSnapshot(30), start.Add(time.Minute))
+
+	// The device rebooted while the exporter was down, and its uptime has
+	// since grown beyond the last one seen
+	tracker = newRebootTracker(path, testBootTimeTolerance)
+	devices := tracker.update(uptimeSnapshot(7200), start.Add(6*time.Hour))
+	if got := devices["d1"].Reboots; got != 2 {
+		t.Errorf("reboots after restarting = %d, want 2", got)
+	}
+
+	// Without a reboot the counter carries over unchanged
+	tracker = newRebootTracker(path, testBootTimeTolerance)
+	devices = tracker.update(uptimeSnapshot(7800), start.Add(6*time.Hour+10*time.Minute))
+	if got := devices["d1"].Reboots; got != 2 {
+		t.Errorf("reboots after restarting without a reboot = %d, want 2", got)
+	}
+}
+
+func TestRebootTrackerPruning(t *testing.T) {
+	tracker := newRebootTracker("", testBootTimeTolerance)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker.update(uptimeSnapshot(3600), start)
+
+	// Devices of a site whose inventory failed are kept
+	devices := tracker.update(testSnapshot(instanton.Site{ID: "s1"}, nil), start.Add(time.Minute))
+	if _, ok := devices["d1"]; !ok {
+		t.Errorf("device d1 was forgotten while the inventory failed")
+	}
+
+	devices = tracker.update(testSnapshot(instanton.Site{ID: "s1"}, []instanton.Device{}), start.Add(2*time.Minute))
+	if _, ok := devices["d1"]; ok {
+		t.Errorf("device d1 is still tracked after leaving the inventory")
+	}
+}
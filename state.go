@@ -0,0 +1,195 @@
+package main
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	deviceUpDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_up",
+		"Whether the device status is up (1) or not (0)",
+		[]string{"site_id", "site_name", "device_id", "device_name"}, nil,
+	)
+
+	deviceStatusDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_status",
+		"Device status as a state set: 1 for the current status, 0 for the others",
+		[]string{"site_id", "site_name", "device_id", "device_name", "status"}, nil,
+	)
+
+	deviceOperationalStateDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_operational_state",
+		"Device operational state as a state set: 1 for the current state, 0 for the others",
+		[]string{"site_id", "site_name", "device_id", "device_name", "state"}, nil,
+	)
+
+	deviceStateTransitionsDesc = prometheus.NewDesc(
+		"aruba_instant_on_device_state_transitions_total",
+		"Number of changes of the device status or operational state observed by the exporter",
+		[]string{"site_id", "site_name", "device_id", "device_name", "kind"}, nil,
+	)
+
+	siteHealthDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_health",
+		"Site health as a state set: 1 for the current health, 0 for the others",
+		[]string{"site_id", "site_name", "health"}, nil,
+	)
+
+	siteStatusDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_status",
+		"Site status as a state set: 1 for the current status, 0 for the others",
+		[]string{"site_id", "site_name", "status"}, nil,
+	)
+
+	siteStateTransitionsDesc = prometheus.NewDesc(
+		"aruba_instant_on_site_state_transitions_total",
+		"Number of changes of the site health or status observed by the exporter",
+		[]string{"site_id", "site_name", "kind"}, nil,
+	)
+)
+
+// Kinds of tracked state. State values are lowercased; values missing from
+// the known lists below are added to a state set once they are observed.
+const (
+	stateDeviceStatus           = "status"
+	stateDeviceOperationalState = "operational_state"
+	stateSiteHealth             = "health"
+	stateSiteStatus             = "status"
+)
+
+var (
+	knownDeviceStatuses = []string{"up", "down"}
+	knownSiteHealths    = []string{"good", "fair", "poor"}
+	knownSiteStatuses   = []string{"up", "down"}
+)
+
+// stateKey identifies one tracked state. deviceID is empty for site states.
+type stateKey struct {
+	siteID   string
+	deviceID string
+	kind     string
+}
+
+// trackedState is the state of one site or device across collections.
+type trackedState struct {
+	current     string
+	states      []string
+	transitions int
+	generation  uint64
+}
+
+// stateTracker remembers site and device states across collections to
+// count transitions and keep every state seen in the state sets. It is
+// only used under Collector.mu.
+type stateTracker struct {
+	states     map[stateKey]*trackedState
+	generation uint64
+}
+
+func newStateTracker() *stateTracker {
+	return &stateTracker{states: make(map[stateKey]*trackedState)}
+}
+
+// update records the states of a fresh snapshot and returns a copy of all
+// tracked states for it. States of sites and devices that are gone are
+// forgotten, except for devices of sites whose inventory could not be
+// fetched this time.
+func (t *stateTracker) update(snap *snapshot) map[stateKey]trackedState {
+	t.generation++
+
+	keepSites := make(map[string]bool)
+	for _, s := range snap.sites {
+		site := s.site
+		t.observe(stateKey{site.ID, "", stateSiteHealth}, site.Health, knownSiteHealths)
+		t.observe(stateKey{site.ID, "", stateSiteStatus}, site.Status, knownSiteStatuses)
+
+		if s.inventory == nil {
+			keepSites[site.ID] = true
+			continue
+		}
+		for _, device := range uniqueDevices(s.inventory.Elements) {
+			t.observe(stateKey{site.ID, device.ID, stateDeviceStatus}, device.Status, knownDeviceStatuses)
+			t.observe(stateKey{site.ID, device.ID, stateDeviceOperationalState}, device.OperationalState, nil)
+		}
+	}
+
+	states := make(map[stateKey]trackedState, len(t.states))
+	for key, state := range t.states {
+		if state.generation != t.generation && !(key.deviceID != "" && keepSites[key.siteID]) {
+			delete(t.states, key)
+			continue
+		}
+		copied := *state
+		copied.states = slices.Clone(state.states)
+		states[key] = copied
+	}
+	return states
+}
+
+func (t *stateTracker) observe(key stateKey, value string, known []string) {
+	if value == "" {
+		return
+	}
+	value = strings.ToLower(value)
+
+	state, ok := t.states[key]
+	if !ok {
+		state = &trackedState{states: slices.Clone(known)}
+		t.states[key] = state
+	} else if state.current != value {
+		state.transitions++
+	}
+	state.current = value
+	state.generation = t.generation
+	if !slices.Contains(state.states, value) {
+		state.states = append(state.states, value)
+		slices.Sort(state.states)
+	}
+}
+
+// collectStates emits the state sets, up gauges and transition counters of
+// a site and its devices.
+func collectStates(ch chan<- prometheus.Metric, s siteSnapshot, states map[stateKey]trackedState) {
+	site := s.site
+
+	emitStateSet(ch, siteHealthDesc, states[stateKey{site.ID, "", stateSiteHealth}], site.ID, site.Name)
+	emitStateSet(ch, siteStatusDesc, states[stateKey{site.ID, "", stateSiteStatus}], site.ID, site.Name)
+	for _, kind := range []string{stateSiteHealth, stateSiteStatus} {
+		if state, ok := states[stateKey{site.ID, "", kind}]; ok {
+			ch <- prometheus.MustNewConstMetric(siteStateTransitionsDesc, prometheus.CounterValue, float64(state.transitions), site.ID, site.Name, kind)
+		}
+	}
+
+	if s.inventory == nil {
+		return
+	}
+	for _, device := range uniqueDevices(s.inventory.Elements) {
+		up := 0.0
+		if strings.EqualFold(device.Status, "up") {
+			up = 1
+		}
+		ch <- prometheus.MustNewConstMetric(deviceUpDesc, prometheus.GaugeValue, up, site.ID, site.Name, device.ID, device.Name)
+
+		emitStateSet(ch, deviceStatusDesc, states[stateKey{site.ID, device.ID, stateDeviceStatus}], site.ID, site.Name, device.ID, device.Name)
+		emitStateSet(ch, deviceOperationalStateDesc, states[stateKey{site.ID, device.ID, stateDeviceOperationalState}], site.ID, site.Name, device.ID, device.Name)
+		for _, kind := range []string{stateDeviceStatus, stateDeviceOperationalState} {
+			if state, ok := states[stateKey{site.ID, device.ID, kind}]; ok {
+				ch <- prometheus.MustNewConstMetric(deviceStateTransitionsDesc, prometheus.CounterValue, float64(state.transitions), site.ID, site.Name, device.ID, device.Name, kind)
+			}
+		}
+	}
+}
+
+// emitStateSet emits one series per state, with the state as last label.
+func emitStateSet(ch chan<- prometheus.Metric, desc *prometheus.Desc, state trackedState, labels ...string) {
+	for _, value := range state.states {
+		v := 0.0
+		if value == state.current {
+			v = 1
+		}
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, v, append(labels, value)...)
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/csenet/instanton-exporter/instanton"
+)
+
+// testSnapshot returns a snapshot of one site holding devices, or a site
+// whose inventory failed if devices is nil.
+func testSnapshot(site instanton.Site, devices []instanton.Device) *snapshot {
+	s := siteSnapshot{site: site}
+	if devices != nil {
+		s.inventory = &instanton.InventoryResponse{TotalCount: len(devices), Elements: devices}
+	}
+	return &snapshot{up: true, sites: []siteSnapshot{s}}
+}
+
+func TestStateTrackerTransitions(t *testing.T) {
+	tracker := newStateTracker()
+	site := instanton.Site{ID: "s1", Health: "Good", Status: "Up"}
+	device := instanton.Device{ID: "d1", Status: "Up", OperationalState: "operational"}
+	statusKey := stateKey{"s1", "d1", stateDeviceStatus}
+	healthKey := stateKey{"s1", "", stateSiteHealth}
+
+	states := tracker.update(testSnapshot(site, []instanton.Device{device}))
+	if got := states[statusKey]; got.current != "up" || got.transitions != 0 {
+		t.Errorf("initial device status = %q with %d transitions, want \"up\" with 0", got.current, got.transitions)
+	}
+	if got := states[healthKey].states; !slices.Equal(got, knownSiteHealths) {
+		t.Errorf("initial site health states = %v, want %v", got, knownSiteHealths)
+	}
+
+	// An unchanged state is no transition
+	states = tracker.update(testSnapshot(site, []instanton.Device{device}))
+	if got := states[statusKey].transitions; got != 0 {
+		t.Errorf("transitions after an unchanged status = %d, want 0", got)
+	}
+
+	device.Status = "Down"
+	site.Health = "Degraded"
+	states = tracker.update(testSnapshot(site, []instanton.Device{device}))
+	if got := states[statusKey]; got.current != "down" || got.transitions != 1 {
+		t.Errorf("device status = %q with %d transitions, want \"down\" with 1", got.current, got.transitions)
+	}
+	// States beyond the known ones join the state set once observed
+	want := []string{"degraded", "fair", "good", "poor"}
+	if got := states[healthKey]; got.current != "degraded" || got.transitions != 1 || !slices.Equal(got.states, want) {
+		t.Errorf("site health = %q with %d transitions and states %v, want \"degraded\" with 1 and %v", got.current, got.transitions, got.states, want)
+	}
+}
+
+func TestStateTrackerPruning(t *testing.T) {
+	tracker := newStateTracker()
+	site := instanton.Site{ID: "s1", Health: "good", Status: "up"}
+	devices := []instanton.Device{{ID: "d1", Status: "up"}, {ID: "d2", Status: "up"}}
+	tracker.update(testSnapshot(site, devices))
+
+	// Devices of a site whose inventory failed are kept
+	states := tracker.update(testSnapshot(site, nil))
+	for _, id := range []string{"d1", "d2"} {
+		if _, ok := states[stateKey{"s1", id, stateDeviceStatus}]; !ok {
+			t.Errorf("device %s was forgotten while the inventory failed", id)
+		}
+	}
+
+	// Devices gone from the inventory are forgotten
+	states = tracker.update(testSnapshot(site, devices[:1]))
+	if _, ok := states[stateKey{"s1", "d2", stateDeviceStatus}]; ok {
+		t.Errorf("device d2 is still tracked after leaving the inventory")
+	}
+	if _, ok := states[stateKey{"s1", "d1", stateDeviceStatus}]; !ok {
+		t.Errorf("device d1 was forgotten")
+	}
+}
+
+func TestStateTrackerUpdateReturnsCopy(t *testing.T) {
+	tracker := newStateTracker()
+	site := instanton.Site{ID: "s1", Health: "good", Status: "up"}
+	states := tracker.update(testSnapshot(site, []instanton.Device{}))
+	healthKey := stateKey{"s1", "", stateSiteHealth}
+
+	site.Health = "strange"
+	tracker.update(testSnapshot(site, []instanton.Device{}))
+	if got := states[healthKey]; got.current != "good" || slices.Contains(got.states, "strange") {
+		t.Errorf("earlier snapshot changed to %q with states %v", got.current, got.states)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// defaultListenAddress avoids node_exporter's port (9100), so both can run on
+// the same host. The port is not registered on the Prometheus default port
+// allocations page; override it with --web.listen-address if it clashes.
+const defaultListenAddress = ":10039"
+
+// serve exposes handler on every configured listener until one of them fails
+// or ctx is cancelled, in which case the server is shut down gracefully.
+// TLS and basic auth are configured by the exporter-toolkit web config file.
+func serve(ctx context.Context, handler http.Handler, cfg WebConfig) error {
+	if cfg.ConfigFile != "" {
+		if err := web.Validate(cfg.ConfigFile); err != nil {
+			return fmt.Errorf("invalid web config file %s: %w", cfg.ConfigFile, err)
+		}
+	}
+
+	listeners, err := listen(cfg)
+	if err != nil {
+		return err
+	}
+	for _, l := range listeners {
+		defer l.Close()
+	}
+
+	flags := &web.FlagConfig{
+		WebListenAddresses: &cfg.ListenAddresses,
+		WebSystemdSocket:   &cfg.SystemdSocket,
+		WebConfigFile:      &cfg.ConfigFile,
+	}
+
+	server := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	err = web.ServeMultiple(listeners, server, flags, slog.Default())
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
+
+// listen opens the systemd-activated sockets if requested, or otherwise one
+// listener per address. Addresses prefixed with "unix://" are unix sockets.
+func listen(cfg WebConfig) ([]net.Listener, error) {
+	if cfg.SystemdSocket {
+		listeners, err := activation.Listeners()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get systemd activated sockets: %w", err)
+		}
+		if len(listeners) == 0 {
+			return nil, errors.New("no systemd activated sockets found")
+		}
+		return listeners, nil
+	}
+
+	listeners := make([]net.Listener, 0, len(cfg.ListenAddresses))
+	for _, address := range cfg.ListenAddresses {
+		l, err := listenAddress(address)
+		if err != nil {
+			for _, opened := range listeners {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", address, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+func listenAddress(address string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(address, "unix://")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+
+	// Remove a socket left behind by a previous run, but never a regular file
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	return net.Listen("unix", path)
+}
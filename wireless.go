@@ -0,0 +1,279 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/csenet/instanton-exporter/instanton"
+)
+
+var (
+	wirelessClientsDesc = prometheus.NewDesc(
+		"aruba_instant_on_wireless_clients",
+		"Number of wireless clients by access point, SSID, band, client health and signal quality",
+		[]string{"site_id", "site_name", "device_id", "device_name", "network_ssid", "band", "health", "signal_quality"}, nil,
+	)
+
+	// signalBuckets are upper bounds in dBm, around the usual -67 dBm
+	// target for voice and -75 dBm floor for data
+	signalBuckets = []float64{-90, -85, -80, -75, -70, -67, -65, -60, -55, -50}
+	snrBuckets    = []float64{5, 10, 15, 20, 25, 30, 40}
+
+	wirelessSignalDesc = prometheus.NewDesc(
+		"aruba_instant_on_wireless_signal_dbm",
+		"Distribution of the signal strength of wireless clients by access point and SSID, in dBm",
+		[]string{"site_id", "site_name", "device_id", "device_name", "network_ssid"}, nil,
+	)
+
+	wirelessSNRDesc = prometheus.NewDesc(
+		"aruba_instant_on_wireless_snr_db",
+		"Distribution of the signal-to-noise ratio of wireless clients by access point and SSID, in dB",
+		[]string{"site_id", "site_name", "device_id", "device_name", "network_ssid"}, nil,
+	)
+
+	// Per-client metrics, only collected with --collector.client-metrics
+	// since they create series for every client
+	clientLabels = []string{"site_id", "site_name", "client_mac", "client_name", "device_id", "device_name"}
+
+	clientSignalDesc = prometheus.NewDesc(
+		"aruba_instant_on_client_signal_dbm",
+		"Signal strength of a wireless client as seen by its access point, in dBm",
+		clientLabels, nil,
+	)
+
+	clientSNRDesc = prometheus.NewDesc(
+		"aruba_instant_on_client_snr_db",
+		"Signal-to-noise ratio of a wireless client, in dB",
+		clientLabels, nil,
+	)
+
+	clientConnectionDurationDesc = prometheus.NewDesc(
+		"aruba_instant_on_client_connection_duration_seconds",
+		"Time a wireless client has been connected to its access point",
+		clientLabels, nil,
+	)
+
+	clientMetricsSkippedDesc = prometheus.NewDesc(
+		"aruba_instant_on_client_metrics_skipped_clients",
+		"Number of wireless clients left out of the per-client metrics because of the max clients cap",
+		nil, nil,
+	)
+)
+
+// collectClientMetrics emits the per-client metrics of a site for at most
+// limit clients and returns how many it emitted. Clients are ordered by MAC
+// address so the same ones are kept across scrapes.
+func collectClientMetrics(ch chan<- prometheus.Metric, s siteSnapshot, limit int) int {
+	if s.wireless == nil {
+		return 0
+	}
+	site := s.site
+
+	apNames := deviceNames(s)
+	clients := uniqueClients(s.wireless.Elements)
+	sort.Slice(clients, func(i, j int) bool { return clientKey(clients[i]) < clientKey(clients[j]) })
+
+	emitted := 0
+	for _, client := range clients {
+		if emitted >= limit {
+			break
+		}
+
+		apName := apNames.name(client)
+		name := client.HostName
+		if name == "" {
+			name = client.Name
+		}
+		labels := []string{site.ID, site.Name, clientKey(client), name, client.DeviceId, apName}
+
+		// A signal of 0 dBm means the AP did not report RF data
+		if client.SignalInDbm != 0 {
+			ch <- prometheus.MustNewConstMetric(clientSignalDesc, prometheus.GaugeValue, float64(client.SignalInDbm), labels...)
+			ch <- prometheus.MustNewConstMetric(clientSNRDesc, prometheus.GaugeValue, float64(client.SnrInDb), labels...)
+		}
+		ch <- prometheus.MustNewConstMetric(clientConnectionDurationDesc, prometheus.GaugeValue, float64(client.ConnectionDurationInSeconds), labels...)
+		emitted++
+	}
+	return emitted
+}
+
+// histogram accumulates observations into a const histogram.
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+}
+
+func (h *histogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.bounds))
+	for i, bound := range h.bounds {
+		buckets[bound] = h.counts[i]
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, labels...)
+}
+
+// collectSignalHistograms emits the signal and SNR distributions of the
+// clients of a site per access point and SSID. Clients the AP reports no RF
+// data for are left out.
+func collectSignalHistograms(ch chan<- prometheus.Metric, s siteSnapshot) {
+	if s.wireless == nil {
+		return
+	}
+	site := s.site
+	apNames := deviceNames(s)
+
+	type key struct {
+		deviceID string
+		ssid     string
+	}
+	type histograms struct {
+		apName string
+		signal *histogram
+		snr    *histogram
+	}
+	groups := make(map[key]*histograms)
+	for _, client := range uniqueClients(s.wireless.Elements) {
+		if client.SignalInDbm == 0 {
+			continue
+		}
+		k := key{client.DeviceId, client.WirelessNetworkName}
+		g, ok := groups[k]
+		if !ok {
+			g = &histograms{
+				apName: apNames.name(client),
+				signal: newHistogram(signalBuckets),
+				snr:    newHistogram(snrBuckets),
+			}
+			groups[k] = g
+		}
+		g.signal.observe(float64(client.SignalInDbm))
+		g.snr.observe(float64(client.SnrInDb))
+	}
+
+	for k, g := range groups {
+		labels := []string{site.ID, site.Name, k.deviceID, g.apName, k.ssid}
+		ch <- g.signal.metric(wirelessSignalDesc, labels...)
+		ch <- g.snr.metric(wirelessSNRDesc, labels...)
+	}
+}
+
+// collectClientBreakdown emits the number of clients of a site for every
+// combination of AP, SSID, band, health and signal quality that occurs.
+func collectClientBreakdown(ch chan<- prometheus.Metric, s siteSnapshot) {
+	if s.wireless == nil {
+		return
+	}
+	site := s.site
+	apNames := deviceNames(s)
+
+	type key struct {
+		deviceID, ssid, band, health, quality string
+	}
+	counts := make(map[key]int)
+	names := make(map[string]string)
+	for _, client := range uniqueClients(s.wireless.Elements) {
+		k := key{
+			deviceID: client.DeviceId,
+			ssid:     client.WirelessNetworkName,
+			band:     bandLabel(client.WirelessBand),
+			health:   valueOrUnknown(client.Health),
+			quality:  valueOrUnknown(client.SignalQuality),
+		}
+		counts[k]++
+		names[client.DeviceId] = apNames.name(client)
+	}
+
+	for k, count := range counts {
+		ch <- prometheus.MustNewConstMetric(wirelessClientsDesc, prometheus.GaugeValue, float64(count),
+			site.ID, site.Name, k.deviceID, names[k.deviceID], k.ssid, k.band, k.health, k.quality)
+	}
+}
+
+// bandLabel maps the band reported by the API to "2.4GHz", "5GHz" or
+// "6GHz". Unrecognised values are passed through.
+func bandLabel(band string) string {
+	b := strings.ToLower(strings.NewReplacer(" ", "", "_", "", ".", "").Replace(band))
+	switch {
+	case b == "":
+		return "unknown"
+	case strings.Contains(b, "24") || strings.Contains(b, "twopointfour"):
+		return "2.4GHz"
+	case strings.HasPrefix(b, "5") || strings.Contains(b, "five"):
+		return "5GHz"
+	case strings.HasPrefix(b, "6") || strings.Contains(b, "six"):
+		return "6GHz"
+	}
+	return band
+}
+
+func valueOrUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// deviceNameMap maps device IDs to their inventory names.
+type deviceNameMap map[string]string
+
+// deviceNames names APs after the inventory, as clients_by_ap does, so a
+// renamed AP does not show up under its old name from client records.
+func deviceNames(s siteSnapshot) deviceNameMap {
+	names := make(deviceNameMap)
+	if s.inventory != nil {
+		for _, device := range s.inventory.Elements {
+			names[device.ID] = device.Name
+		}
+	}
+	return names
+}
+
+// name returns the name of the AP a client is connected to.
+func (m deviceNameMap) name(client instanton.WirelessClient) string {
+	if name, ok := m[client.DeviceId]; ok {
+		return name
+	}
+	return client.DeviceName
+}
+
+// clientKey identifies a client by MAC address, or by ID if the API did not
+// report the MAC.
+func clientKey(client instanton.WirelessClient) string {
+	if client.MacAddress != "" {
+		return client.MacAddress
+	}
+	return client.ID
+}
+
+// uniqueClients drops repeated entries for the same client, which would
+// otherwise be emitted as duplicate series and fail the whole scrape.
+func uniqueClients(clients []instanton.WirelessClient) []instanton.WirelessClient {
+	seen := make(map[string]bool, len(clients))
+	unique := make([]instanton.WirelessClient, 0, len(clients))
+	for _, client := range clients {
+		key := clientKey(client)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, client)
+	}
+	return unique
+}